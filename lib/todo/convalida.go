@@ -0,0 +1,172 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package todo
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	//CampoTesto è il nome del campo testo della nota negli errori di convalida.
+	CampoTesto string = "testo"
+	//CampoCorpo è il nome del campo corpo della nota negli errori di convalida.
+	CampoCorpo string = "corpo"
+)
+
+//LunghezzaTestoMassima è il numero massimo di caratteri del testo previsto dalla tabella delle note.
+const LunghezzaTestoMassima int = 200
+
+//ErroreCampo descrive un errore di convalida relativo a un campo della nota.
+type ErroreCampo struct {
+	Campo     string `json:"campo"`
+	Regola    string `json:"regola"`
+	Messaggio string `json:"messaggio"`
+}
+
+//Error restituisce il messaggio dell'errore preceduto dal nome del campo.
+func (ec ErroreCampo) Error() string {
+	return ec.Campo + ": " + ec.Messaggio
+}
+
+//Is permette a errors.Is di riconoscere l'errore come ErrNotaNonValida.
+func (ec ErroreCampo) Is(target error) bool {
+	return (target == ErrNotaNonValida)
+}
+
+//ErroriConvalida raccoglie gli errori di convalida di una nota.
+type ErroriConvalida []ErroreCampo
+
+//Error restituisce i messaggi degli errori separati da punto e virgola.
+func (ec ErroriConvalida) Error() string {
+	msg := make([]string, 0, len(ec))
+	for _, e := range ec {
+		msg = append(msg, e.Error())
+	}
+	return strings.Join(msg, "; ")
+}
+
+//Is permette a errors.Is di riconoscere l'errore come ErrNotaNonValida.
+func (ec ErroriConvalida) Is(target error) bool {
+	return (target == ErrNotaNonValida)
+}
+
+//Messaggi restituisce i messaggi degli errori relativi al campo specificato.
+func (ec ErroriConvalida) Messaggi(campo string) (msg []string) {
+	for _, e := range ec {
+		if e.Campo == campo {
+			msg = append(msg, e.Messaggio)
+		}
+	}
+	return
+}
+
+/*
+Regola è il tipo funzione per convalidare una nota.
+
+La funzione deve restituire nil se la nota soddisfa la regola, altrimenti
+un ErroreCampo, un ErroriConvalida oppure un altro errore che sarà riportato
+come errore del campo testo.
+*/
+type Regola func(gn *Gestore, nt *Nota) error
+
+//RegoleDefault restituisce le regole usate da un gestore creato con NewGestore:
+//testo obbligatorio e lunghezza massima del testo pari a LunghezzaTestoMassima.
+func RegoleDefault() []Regola {
+	return []Regola{TestoObbligatorio(), LunghezzaMassima(CampoTesto, LunghezzaTestoMassima)}
+}
+
+//TestoObbligatorio restituisce una regola che non ammette testi vuoti o composti da soli spazi.
+func TestoObbligatorio() Regola {
+	return func(gn *Gestore, nt *Nota) error {
+		if len(strings.TrimSpace(nt.testo)) == 0 {
+			return ErroreCampo{Campo: CampoTesto, Regola: "obbligatorio", Messaggio: "Specifica il testo della nota."}
+		}
+		return nil
+	}
+}
+
+//LunghezzaMassima restituisce una regola che non ammette più di max caratteri (rune) nel campo specificato.
+func LunghezzaMassima(campo string, max int) Regola {
+	return func(gn *Gestore, nt *Nota) error {
+		if n := utf8.RuneCountInString(valoreCampo(nt, campo)); n > max {
+			return ErroreCampo{Campo: campo, Regola: "lunghezza", Messaggio: fmt.Sprintf("Al massimo %d caratteri, ne hai inseriti %d.", max, n)}
+		}
+		return nil
+	}
+}
+
+//CaratteriVietati restituisce una regola che non ammette nel campo specificato
+//nessuno dei caratteri elencati in caratteri.
+func CaratteriVietati(campo string, caratteri string) Regola {
+	return func(gn *Gestore, nt *Nota) error {
+		if i := strings.IndexAny(valoreCampo(nt, campo), caratteri); i >= 0 {
+			r, _ := utf8.DecodeRuneInString(valoreCampo(nt, campo)[i:])
+			return ErroreCampo{Campo: campo, Regola: "caratteri", Messaggio: fmt.Sprintf("Il carattere %q non è ammesso.", r)}
+		}
+		return nil
+	}
+}
+
+//NessunDuplicato restituisce una regola che non ammette due note da fare con lo stesso testo,
+//senza distinguere fra maiuscole e minuscole, fra quelle a cui l'utente attivo può accedere.
+func NessunDuplicato() Regola {
+	return func(gn *Gestore, nt *Nota) error {
+		if !gn.Pronto() || nt.Fatto {
+			return nil
+		}
+		var tot int
+		cond, args := gn.accesso("note", PermessoLettura)
+		args = append([]interface{}{false, nt.id, nt.testo}, args...)
+		if err := gn.base.QueryRow("SELECT COUNT(*) FROM note WHERE fatto = ? AND id <> ? AND LOWER(testo) = LOWER(?) AND "+cond+";", args...).Scan(&tot); err != nil {
+			return err
+		}
+		if tot > 0 {
+			return ErroreCampo{Campo: CampoTesto, Regola: "duplicato", Messaggio: "C'è già una nota da fare con questo testo."}
+		}
+		return nil
+	}
+}
+
+//ImpostaRegole sostituisce le regole di convalida del gestore con quelle specificate.
+//Senza regole le note sono sempre valide.
+func (gn *Gestore) ImpostaRegole(regole ...Regola) {
+	gn.regole = append([]Regola(nil), regole...)
+}
+
+//AggiungiRegole aggiunge le regole specificate a quelle di convalida del gestore.
+func (gn *Gestore) AggiungiRegole(regole ...Regola) {
+	gn.regole = append(gn.regole, regole...)
+}
+
+//Convalida applica alla nota tutte le regole del gestore nell'ordine in cui sono state impostate.
+//Restituisce nil se la nota è valida, altrimenti un ErroriConvalida con tutti gli errori trovati.
+func (gn *Gestore) Convalida(nt *Nota) error {
+	var errori ErroriConvalida
+	for _, regola := range gn.regole {
+		switch e := regola(gn, nt).(type) {
+		case nil:
+		case ErroreCampo:
+			errori = append(errori, e)
+		case ErroriConvalida:
+			errori = append(errori, e...)
+		default:
+			errori = append(errori, ErroreCampo{Campo: CampoTesto, Regola: "personalizzata", Messaggio: e.Error()})
+		}
+	}
+	if len(errori) > 0 {
+		return errori
+	}
+	return nil
+}
+
+// valoreCampo restituisce il valore del campo della nota con il nome specificato.
+func valoreCampo(nt *Nota, campo string) string {
+	if campo == CampoCorpo {
+		return nt.corpo
+	}
+	return nt.testo
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package todo
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+/*
+Dialetto descrive come usare un database SQL con il gestore delle note.
+
+Le istruzioni della libreria sono scritte nella sintassi di SQLite, con i segnaposto ?
+e le chiavi primarie "INTEGER PRIMARY KEY ASC AUTOINCREMENT": il dialetto le converte
+nella sintassi del database e recupera l'id delle righe inserite.
+
+I dialetti disponibili sono SQLite e PostgreSQL.
+*/
+type Dialetto interface {
+	// Driver restituisce il nome del driver registrato nel package database/sql.
+	Driver() string
+	// Converti restituisce la query convertita nella sintassi del database.
+	Converti(query string) string
+	// Inserisci esegue la query di inserimento e restituisce l'id della riga inserita.
+	Inserisci(db *sql.DB, query string, args ...interface{}) (int64, error)
+}
+
+//SQLite è il dialetto per i database SQLite 3 con il driver "sqlite3".
+var SQLite Dialetto = dialettoSQLite{}
+
+//PostgreSQL è il dialetto per i database PostgreSQL con il driver "postgres",
+//che deve essere importato dall'applicazione, ad esempio con github.com/lib/pq.
+var PostgreSQL Dialetto = PostgreSQLDriver("postgres")
+
+//PostgreSQLDriver restituisce il dialetto PostgreSQL per il driver con il nome specificato,
+//ad esempio "pgx" per github.com/jackc/pgx/v5/stdlib.
+func PostgreSQLDriver(driver string) Dialetto {
+	return dialettoPostgreSQL{driver: driver}
+}
+
+// ===== Tipo dialettoSQLite =====
+
+type dialettoSQLite struct{}
+
+func (dialettoSQLite) Driver() string {
+	return "sqlite3"
+}
+
+func (dialettoSQLite) Converti(query string) string {
+	return query
+}
+
+func (dialettoSQLite) Inserisci(db *sql.DB, query string, args ...interface{}) (id int64, err error) {
+	var res sql.Result
+	if res, err = db.Exec(query, args...); err != nil {
+		return -1, err
+	}
+	return res.LastInsertId()
+}
+
+// ===== Tipo dialettoPostgreSQL =====
+
+type dialettoPostgreSQL struct {
+	driver string
+}
+
+func (d dialettoPostgreSQL) Driver() string {
+	return d.driver
+}
+
+// Converti sostituisce i segnaposto ? con $1, $2... ignorando quelli fra apici
+// e le chiavi primarie AUTOINCREMENT con BIGSERIAL.
+func (dialettoPostgreSQL) Converti(query string) string {
+	query = strings.Replace(query, "INTEGER PRIMARY KEY ASC AUTOINCREMENT", "BIGSERIAL PRIMARY KEY", -1)
+
+	var sb strings.Builder
+	n := 0
+	apici := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			apici = !apici
+		case c == '?' && !apici:
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// Inserisci aggiunge la clausola RETURNING id alla query per recuperare l'id della riga inserita.
+func (d dialettoPostgreSQL) Inserisci(db *sql.DB, query string, args ...interface{}) (id int64, err error) {
+	query = d.Converti(strings.TrimSuffix(strings.TrimSpace(query), ";") + " RETURNING id;")
+	if err = db.QueryRow(query, args...).Scan(&id); err != nil {
+		id = -1
+	}
+	return
+}
+
+// ===== Tipo baseDati =====
+
+// baseDati associa la connessione al database con il suo dialetto
+// e converte le query prima di eseguirle.
+type baseDati struct {
+	*sql.DB
+	dialetto Dialetto
+}
+
+func (bd *baseDati) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return bd.DB.Exec(bd.dialetto.Converti(query), args...)
+}
+
+func (bd *baseDati) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return bd.DB.Query(bd.dialetto.Converti(query), args...)
+}
+
+func (bd *baseDati) QueryRow(query string, args ...interface{}) *sql.Row {
+	return bd.DB.QueryRow(bd.dialetto.Converti(query), args...)
+}
+
+// Inserisci esegue la query di inserimento e restituisce l'id della riga inserita.
+func (bd *baseDati) Inserisci(query string, args ...interface{}) (int64, error) {
+	return bd.dialetto.Inserisci(bd.DB, query, args...)
+}
+
+func (bd *baseDati) Begin() (*transazione, error) {
+	tx, err := bd.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transazione{Tx: tx, dialetto: bd.dialetto}, nil
+}
+
+// ===== Tipo transazione =====
+
+// transazione associa una transazione con il dialetto del database.
+type transazione struct {
+	*sql.Tx
+	dialetto Dialetto
+}
+
+func (tx *transazione) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialetto.Converti(query), args...)
+}
+
+func (tx *transazione) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialetto.Converti(query), args...)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package todo
+
+import (
+	"database/sql"
+	"errors"
+)
+
+//TipoLegame rappresenta il tipo di legame fra due note.
+type TipoLegame int
+
+const (
+	//LegameCorrelato indica che due note sono correlate.
+	LegameCorrelato TipoLegame = 0
+	//LegameBlocca indica che una nota blocca un'altra nota finché non è fatta.
+	LegameBlocca TipoLegame = 1
+)
+
+//ErrLegameNonValido è restituito quando un legame non è valido, ad esempio fra una nota e sé stessa.
+var ErrLegameNonValido error = errors.New("legame non valido")
+
+//ErrCicloDipendenze è restituito quando un legame di blocco creerebbe un ciclo fra le note.
+var ErrCicloDipendenze error = errors.New("il legame crea un ciclo di dipendenze")
+
+//ErrNotaBloccata è restituito quando una nota non può essere fatta perché bloccata da note da fare.
+var ErrNotaBloccata error = errors.New("nota bloccata da note da fare")
+
+const createLegamiStmt string = "CREATE TABLE IF NOT EXISTS legami (da INTEGER NOT NULL, a INTEGER NOT NULL, tipo INTEGER NOT NULL, PRIMARY KEY (da, a));"
+
+
+/*
+Collega crea un legame fra due note e restituisce nil in caso di successo.
+Con il tipo LegameBlocca la nota IDNota blocca la nota IDAltra, che non potrà essere
+fatta finché IDNota è da fare. Con il tipo LegameCorrelato le due note sono solo correlate.
+
+Un eventuale legame già presente fra le due note è sostituito dal nuovo.
+
+Restituisce ErrGestoreNonPronto se il gestore non è pronto, ErrLegameNonValido se le note
+coincidono o il tipo non è valido, ErrNotaNonTrovata se una delle note non è accessibile,
+ErrPermessoNegato se l'utente attivo non può modificare entrambe le note,
+ErrCicloDipendenze se il blocco creerebbe un ciclo, oppure l'eventuale errore SQL.
+*/
+func (gn *Gestore) Collega(IDNota, IDAltra int64, tipo TipoLegame) (err error) {
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	if IDNota == IDAltra || (tipo != LegameCorrelato && tipo != LegameBlocca) {
+		err = ErrLegameNonValido
+		return
+	}
+
+	for _, id := range []int64{IDNota, IDAltra} {
+		if err = gn.richiediNota(id, PermessoModifica); err != nil {
+			return
+		}
+	}
+
+	var tx *transazione
+	if tx, err = gn.base.Begin(); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec("DELETE FROM legami WHERE (da = ? AND a = ?) OR (da = ? AND a = ?);", IDNota, IDAltra, IDAltra, IDNota); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if tipo == LegameBlocca {
+		// il blocco crea un ciclo se IDAltra blocca già IDNota, anche indirettamente
+		var ciclo bool
+		if ciclo, err = raggiungibile(tx, IDAltra, IDNota); err == nil && ciclo {
+			err = ErrCicloDipendenze
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+
+	if _, err = tx.Exec("INSERT INTO legami (da, a, tipo) values(?, ?, ?);", IDNota, IDAltra, int(tipo)); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+//Scollega rimuove il legame fra due note, indipendentemente dal tipo e dal verso,
+//e restituisce nil in caso di successo.
+//Restituisce ErrGestoreNonPronto se il gestore non è pronto, ErrNotaNonTrovata o ErrPermessoNegato
+//se l'utente attivo non può modificare la nota IDNota, altrimenti l'eventuale errore SQL.
+func (gn *Gestore) Scollega(IDNota, IDAltra int64) (err error) {
+	if err = gn.richiediNota(IDNota, PermessoModifica); err != nil {
+		return
+	}
+
+	_, err = gn.base.Exec("DELETE FROM legami WHERE (da = ? AND a = ?) OR (da = ? AND a = ?);", IDNota, IDAltra, IDAltra, IDNota)
+
+	return
+}
+
+//Bloccanti restituisce le note accessibili all'utente attivo che bloccano la nota con id specificato oppure nil
+//se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) Bloccanti(IDNota int64) (note []Nota) {
+	if !gn.Pronto() {
+		return nil
+	}
+
+	cond, args := gn.accesso("n", PermessoLettura)
+
+	return gn.seleziona("SELECT n.id, n.testo, n.fatto, n.corpo, n.proprietario, n.lista FROM note n JOIN legami l ON l.da = n.id WHERE l.a = ? AND l.tipo = ? AND "+cond+";", append([]interface{}{IDNota, int(LegameBlocca)}, args...)...)
+}
+
+//Bloccate restituisce le note accessibili all'utente attivo bloccate dalla nota con id specificato oppure nil
+//se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) Bloccate(IDNota int64) (note []Nota) {
+	if !gn.Pronto() {
+		return nil
+	}
+
+	cond, args := gn.accesso("n", PermessoLettura)
+
+	return gn.seleziona("SELECT n.id, n.testo, n.fatto, n.corpo, n.proprietario, n.lista FROM note n JOIN legami l ON l.a = n.id WHERE l.da = ? AND l.tipo = ? AND "+cond+";", append([]interface{}{IDNota, int(LegameBlocca)}, args...)...)
+}
+
+//Correlate restituisce le note accessibili all'utente attivo correlate alla nota con id specificato oppure nil
+//se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) Correlate(IDNota int64) (note []Nota) {
+	if !gn.Pronto() {
+		return nil
+	}
+
+	cond, args := gn.accesso("n", PermessoLettura)
+
+	return gn.seleziona("SELECT n.id, n.testo, n.fatto, n.corpo, n.proprietario, n.lista FROM note n JOIN legami l ON (l.da = n.id AND l.a = ?) OR (l.a = n.id AND l.da = ?) WHERE l.tipo = ? AND "+cond+";", append([]interface{}{IDNota, IDNota, int(LegameCorrelato)}, args...)...)
+}
+
+//Bloccata restituisce true se la nota con id specificato è bloccata da almeno una nota da fare.
+//Restituisce false se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) Bloccata(IDNota int64) bool {
+	return (gn.verificaBlocco(IDNota) == ErrNotaBloccata)
+}
+
+//verificaBlocco restituisce ErrNotaBloccata se la nota è bloccata da note da fare,
+//ErrGestoreNonPronto se il gestore non è pronto, l'eventuale errore SQL oppure nil.
+func (gn *Gestore) verificaBlocco(IDNota int64) (err error) {
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	var tot int
+	query, args := gn.bloccate()
+	if err = gn.base.QueryRow("SELECT COUNT(*) FROM ("+query+") AS bl WHERE bl.a = ?;", append(args, IDNota)...).Scan(&tot); err != nil {
+		return
+	}
+
+	if tot > 0 {
+		err = ErrNotaBloccata
+	}
+
+	return
+}
+
+//bloccate restituisce la query che seleziona gli id delle note bloccate da note da fare
+//accessibili all'utente attivo e i relativi argomenti: i legami con note che l'utente non vede sono ignorati.
+func (gn *Gestore) bloccate() (string, []interface{}) {
+	cond, args := gn.accesso("b", PermessoLettura)
+	return "SELECT l.a FROM legami l JOIN note b ON b.id = l.da WHERE l.tipo = ? AND b.fatto = ? AND " + cond, append([]interface{}{int(LegameBlocca), false}, args...)
+}
+
+//raggiungibile restituisce true se la nota con id iniziale blocca, anche indirettamente,
+//la nota con id finale seguendo i legami di blocco.
+func raggiungibile(tx *transazione, iniziale, finale int64) (trovata bool, err error) {
+	var rws *sql.Rows
+	if rws, err = tx.Query("SELECT da, a FROM legami WHERE tipo = ?;", int(LegameBlocca)); err != nil {
+		return
+	}
+
+	// crea la mappa dei blocchi
+	blocchi := make(map[int64][]int64)
+	var da, a int64
+	for rws.Next() {
+		if err = rws.Scan(&da, &a); err != nil {
+			rws.Close()
+			return
+		}
+		blocchi[da] = append(blocchi[da], a)
+	}
+	rws.Close()
+	if err = rws.Err(); err != nil {
+		return
+	}
+
+	// visita in profondità a partire dalla nota iniziale
+	visitate := map[int64]bool{iniziale: true}
+	pila := []int64{iniziale}
+	for len(pila) > 0 {
+		id := pila[len(pila)-1]
+		pila = pila[:len(pila)-1]
+		if id == finale {
+			return true, nil
+		}
+		for _, succ := range blocchi[id] {
+			if !visitate[succ] {
+				visitate[succ] = true
+				pila = append(pila, succ)
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package todo
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+/*
+RenderMarkdown converte un testo in formato Markdown in HTML sicuro.
+
+Tutto il testo è codificato con html.EscapeString, quindi eventuali tag HTML
+presenti nel testo sono mostrati come testo e non possono iniettare script.
+I link sono ammessi solo con percorsi relativi o con gli schemi http, https e mailto.
+
+Sono supportati:
+  titoli (# Titolo), paragrafi, citazioni (> testo) e linee orizzontali (---)
+  elenchi puntati (- voce) e numerati (1. voce)
+  liste di attività (- [ ] da fare, - [x] fatto)
+  blocchi di codice (```) e codice in linea (`codice`)
+  **grassetto**, *corsivo*, [link](http://esempio.it) e indirizzi http:// o https://
+*/
+func RenderMarkdown(src string) template.HTML {
+	var sb strings.Builder
+	righe := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	renderBlocchi(&sb, righe)
+	return template.HTML(sb.String())
+}
+
+var (
+	reTitolo    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	reLinea     = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	rePuntato   = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	reNumerato  = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	reAttivita  = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+	reCitazione = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+)
+
+// renderBlocchi scrive l'HTML degli elementi di blocco contenuti nelle righe.
+func renderBlocchi(sb *strings.Builder, righe []string) {
+	var paragrafo []string
+
+	chiudiParagrafo := func() {
+		if len(paragrafo) > 0 {
+			sb.WriteString("<p>")
+			sb.WriteString(renderInline(strings.Join(paragrafo, "\n")))
+			sb.WriteString("</p>\n")
+			paragrafo = nil
+		}
+	}
+
+	for i := 0; i < len(righe); i++ {
+		riga := righe[i]
+		pura := strings.TrimSpace(riga)
+
+		switch {
+		case pura == "":
+			chiudiParagrafo()
+
+		case strings.HasPrefix(pura, "```"):
+			// blocco di codice fino alla chiusura o alla fine del testo
+			chiudiParagrafo()
+			sb.WriteString("<pre><code>")
+			for i++; i < len(righe) && !strings.HasPrefix(strings.TrimSpace(righe[i]), "```"); i++ {
+				sb.WriteString(html.EscapeString(righe[i]))
+				sb.WriteString("\n")
+			}
+			sb.WriteString("</code></pre>\n")
+
+		case reTitolo.MatchString(pura):
+			chiudiParagrafo()
+			m := reTitolo.FindStringSubmatch(pura)
+			livello := string('0' + rune(len(m[1])))
+			sb.WriteString("<h" + livello + ">" + renderInline(m[2]) + "</h" + livello + ">\n")
+
+		case reLinea.MatchString(riga):
+			chiudiParagrafo()
+			sb.WriteString("<hr>\n")
+
+		case reCitazione.MatchString(riga):
+			chiudiParagrafo()
+			var citazione []string
+			for ; i < len(righe) && reCitazione.MatchString(righe[i]); i++ {
+				citazione = append(citazione, reCitazione.FindStringSubmatch(righe[i])[1])
+			}
+			i--
+			sb.WriteString("<blockquote>\n")
+			renderBlocchi(sb, citazione)
+			sb.WriteString("</blockquote>\n")
+
+		case rePuntato.MatchString(riga), reNumerato.MatchString(riga):
+			chiudiParagrafo()
+			i = renderElenco(sb, righe, i) - 1
+
+		default:
+			paragrafo = append(paragrafo, pura)
+		}
+	}
+
+	chiudiParagrafo()
+}
+
+// renderElenco scrive l'HTML dell'elenco che inizia alla riga specificata
+// e restituisce l'indice della prima riga successiva all'elenco.
+func renderElenco(sb *strings.Builder, righe []string, inizio int) int {
+	re, tag := rePuntato, "ul"
+	if reNumerato.MatchString(righe[inizio]) {
+		re, tag = reNumerato, "ol"
+	}
+
+	// raccoglie le voci unendo le righe di continuazione indentate
+	var voci []string
+	i := inizio
+	for ; i < len(righe); i++ {
+		riga := righe[i]
+		if m := re.FindStringSubmatch(riga); m != nil {
+			voci = append(voci, m[1])
+			continue
+		}
+		if strings.TrimSpace(riga) != "" && (strings.HasPrefix(riga, " ") || strings.HasPrefix(riga, "\t")) {
+			voci[len(voci)-1] += "\n" + strings.TrimSpace(riga)
+			continue
+		}
+		break
+	}
+
+	sb.WriteString("<" + tag + ">\n")
+	for _, voce := range voci {
+		if m := reAttivita.FindStringSubmatch(voce); m != nil {
+			checked := ""
+			if m[1] != " " {
+				checked = " checked"
+			}
+			sb.WriteString(`<li class="task"><input type="checkbox" disabled` + checked + `> ` + renderInline(m[2]) + "</li>\n")
+		} else {
+			sb.WriteString("<li>" + renderInline(voce) + "</li>\n")
+		}
+	}
+	sb.WriteString("</" + tag + ">\n")
+
+	return i
+}
+
+// renderInline restituisce l'HTML degli elementi in linea contenuti nel testo.
+func renderInline(s string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		resto := s[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_[]()<>#+-.!", s[i+1]) >= 0:
+			// carattere letterale
+			sb.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if fine := strings.IndexByte(s[i+1:], '`'); fine >= 0 {
+				sb.WriteString("<code>" + html.EscapeString(s[i+1:i+1+fine]) + "</code>")
+				i += fine + 2
+				continue
+			}
+
+		case strings.HasPrefix(resto, "**"), strings.HasPrefix(resto, "__"):
+			if fine := strings.Index(s[i+2:], resto[:2]); fine > 0 {
+				sb.WriteString("<strong>" + renderInline(s[i+2:i+2+fine]) + "</strong>")
+				i += fine + 4
+				continue
+			}
+
+		case c == '*', c == '_' && (i == 0 || !alfanumerico(s[i-1])):
+			if fine := strings.IndexByte(s[i+1:], c); fine > 0 {
+				sb.WriteString("<em>" + renderInline(s[i+1:i+1+fine]) + "</em>")
+				i += fine + 2
+				continue
+			}
+
+		case c == '[':
+			if testo, dest, n, ok := leggiLink(resto); ok {
+				if href, sicuro := urlSicuro(dest); sicuro {
+					sb.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + renderInline(testo) + "</a>")
+				} else {
+					sb.WriteString(renderInline(testo))
+				}
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if fine := strings.IndexByte(resto, '>'); fine > 0 {
+				if href, sicuro := urlSicuro(resto[1:fine]); sicuro && strings.Contains(href, ":") {
+					sb.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + html.EscapeString(resto[1:fine]) + "</a>")
+					i += fine + 1
+					continue
+				}
+			}
+
+		case strings.HasPrefix(resto, "http://"), strings.HasPrefix(resto, "https://"):
+			if i == 0 || strings.IndexByte(" \t\n(", s[i-1]) >= 0 {
+				fine := strings.IndexAny(resto, " \t\n<")
+				if fine < 0 {
+					fine = len(resto)
+				}
+				indirizzo := strings.TrimRight(resto[:fine], ".,;:!?)")
+				if href, sicuro := urlSicuro(indirizzo); sicuro {
+					sb.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + html.EscapeString(indirizzo) + "</a>")
+					i += len(indirizzo)
+					continue
+				}
+			}
+
+		case c == '\n':
+			sb.WriteString("<br>\n")
+			i++
+			continue
+		}
+
+		sb.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+
+	return sb.String()
+}
+
+// leggiLink riconosce un link nel formato [testo](destinazione) all'inizio di s
+// e restituisce testo, destinazione e numero di byte letti.
+func leggiLink(s string) (testo, dest string, n int, ok bool) {
+	chiusa := strings.Index(s, "](")
+	if chiusa < 0 {
+		return
+	}
+	// cerca la parentesi di chiusura considerando quelle annidate
+	fine, livello := -1, 0
+	for j := chiusa + 2; j < len(s) && fine < 0; j++ {
+		switch s[j] {
+		case '(':
+			livello++
+		case ')':
+			if livello == 0 {
+				fine = j - chiusa - 2
+			}
+			livello--
+		}
+	}
+	if fine < 0 {
+		return
+	}
+	testo = s[1:chiusa]
+	dest = strings.TrimSpace(s[chiusa+2 : chiusa+2+fine])
+	n = chiusa + 3 + fine
+	ok = !strings.ContainsAny(testo, "[]")
+	return
+}
+
+// urlSicuro restituisce l'indirizzo normalizzato e true se l'indirizzo è relativo
+// oppure usa gli schemi http, https o mailto.
+// Gli indirizzi senza schema verso un altro host (//host) non sono considerati relativi,
+// come quelli con la barra rovesciata che i browser trattano come una barra.
+func urlSicuro(indirizzo string) (string, bool) {
+	if indirizzo == "" || strings.ContainsAny(indirizzo, " \t\n\"'<>\\") {
+		return "", false
+	}
+	u, err := url.Parse(indirizzo)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "":
+		if (u.Host != "") || strings.HasPrefix(indirizzo, "//") {
+			return "", false
+		}
+		return u.String(), true
+	case "http", "https", "mailto":
+		return u.String(), true
+	}
+	return "", false
+}
+
+// alfanumerico restituisce true se il byte è una lettera o una cifra ASCII.
+func alfanumerico(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
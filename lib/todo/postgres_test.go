@@ -0,0 +1,16 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+//go:build postgres
+// +build postgres
+
+package todo
+
+// Con il tag postgres i test registrano il driver "postgres" per eseguire
+// i test di conformità anche con il database indicato in TODO_POSTGRES_DSN.
+
+import (
+	//inizializza il driver postgres
+	_ "github.com/lib/pq"
+)
@@ -0,0 +1,478 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package todo
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+//Utente rappresenta un utente proprietario di note e liste.
+type Utente struct {
+	id   int64
+	nome string
+}
+
+//GetID restituisce l'id dell'utente.
+func (ut Utente) GetID() int64 {
+	return ut.id
+}
+
+//GetNome restituisce il nome dell'utente.
+func (ut Utente) GetNome() string {
+	return ut.nome
+}
+
+//String restituisce il nome dell'utente.
+func (ut Utente) String() string {
+	return ut.nome
+}
+
+//Lista rappresenta una lista di note con un proprietario.
+type Lista struct {
+	id           int64
+	nome         string
+	proprietario int64
+}
+
+//GetID restituisce l'id della lista.
+func (ls Lista) GetID() int64 {
+	return ls.id
+}
+
+//GetNome restituisce il nome della lista.
+func (ls Lista) GetNome() string {
+	return ls.nome
+}
+
+//GetProprietario restituisce l'id dell'utente proprietario della lista.
+func (ls Lista) GetProprietario() int64 {
+	return ls.proprietario
+}
+
+//String restituisce il nome della lista.
+func (ls Lista) String() string {
+	return ls.nome
+}
+
+//Permesso rappresenta il livello di accesso di un utente a una nota o a una lista.
+type Permesso int
+
+const (
+	//PermessoNessuno indica che l'utente non può accedere alla nota o alla lista.
+	PermessoNessuno Permesso = 0
+	//PermessoLettura permette di vedere la nota o le note della lista.
+	PermessoLettura Permesso = 1
+	//PermessoModifica permette anche di modificare la nota o le note della lista e di aggiungere note alla lista.
+	PermessoModifica Permesso = 2
+	//PermessoProprietario permette anche di eliminare e condividere la nota o la lista.
+	//Non può essere concesso con la condivisione.
+	PermessoProprietario Permesso = 3
+)
+
+//UtenteDefault è l'id dell'utente a cui appartengono le note dei gestori non associati a un utente
+//e quelle create prima dell'introduzione degli utenti.
+const UtenteDefault int64 = 0
+
+//ErrUtenteNonValido è restituito quando il nome dell'utente non è valido.
+var ErrUtenteNonValido error = errors.New("utente non valido")
+
+//ErrUtenteEsistente è restituito quando esiste già un utente con lo stesso nome.
+var ErrUtenteEsistente error = errors.New("utente già esistente")
+
+//ErrUtenteNonTrovato è restituito quando un utente non è disponibile.
+var ErrUtenteNonTrovato error = errors.New("utente non trovato")
+
+//ErrListaNonTrovata è restituito quando una lista non è disponibile per l'utente attivo.
+var ErrListaNonTrovata error = errors.New("lista non trovata")
+
+//ErrPermessoNegato è restituito quando l'utente attivo non ha il permesso necessario per l'operazione.
+var ErrPermessoNegato error = errors.New("permesso negato")
+
+//ErrPermessoNonValido è restituito quando si condivide una nota o una lista con un permesso non ammesso.
+var ErrPermessoNonValido error = errors.New("permesso non valido")
+
+const createUtentiStmt string = "CREATE TABLE IF NOT EXISTS utenti (id INTEGER PRIMARY KEY ASC AUTOINCREMENT, nome VARCHAR(50) NOT NULL UNIQUE);"
+
+const createListeStmt string = "CREATE TABLE IF NOT EXISTS liste (id INTEGER PRIMARY KEY ASC AUTOINCREMENT, nome VARCHAR(100) NOT NULL, proprietario INTEGER NOT NULL);"
+
+const createCondivisioniStmt string = "CREATE TABLE IF NOT EXISTS condivisioni (tipo VARCHAR(10) NOT NULL, risorsa INTEGER NOT NULL, utente INTEGER NOT NULL, permesso INTEGER NOT NULL, PRIMARY KEY (tipo, risorsa, utente));"
+
+const (
+	risorsaNota  string = "nota"
+	risorsaLista string = "lista"
+)
+
+/*
+PerUtente restituisce un gestore che agisce per conto dell'utente con id specificato.
+
+Il gestore restituito condivide il database e le regole di convalida con gn, ma tutte le
+interrogazioni sono limitate alle note e alle liste a cui l'utente può accedere:
+quelle di cui è proprietario, quelle contenute nelle sue liste e quelle condivise con lui.
+Le note aggiunte appartengono all'utente.
+
+Il gestore restituito da NewGestore agisce per conto di UtenteDefault.
+Chiudi chiude il database comune a tutti i gestori ottenuti da gn.
+*/
+func (gn *Gestore) PerUtente(IDUtente int64) *Gestore {
+	return &Gestore{base: gn.base, regole: gn.regole, utente: IDUtente}
+}
+
+//UtenteAttivo restituisce l'id dell'utente per conto del quale agisce il gestore.
+func (gn *Gestore) UtenteAttivo() int64 {
+	return gn.utente
+}
+
+//AggiungiUtente crea un nuovo utente con il nome specificato e ne restituisce l'id e nil.
+//Se la creazione non riesce, restituisce -1 e ErrGestoreNonPronto se il gestore non è pronto,
+//ErrUtenteNonValido se il nome è vuoto, ErrUtenteEsistente se il nome è già usato, oppure l'errore SQL.
+func (gn *Gestore) AggiungiUtente(nome string) (id int64, err error) {
+	id = -1
+
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	nome = strings.TrimSpace(nome)
+	if len(nome) == 0 {
+		err = ErrUtenteNonValido
+		return
+	}
+
+	if _, err = gn.RecuperaUtente(nome); err == nil {
+		err = ErrUtenteEsistente
+		return
+	} else if err != ErrUtenteNonTrovato {
+		return
+	}
+
+	if id, err = gn.base.Inserisci("INSERT INTO utenti (nome) values(?);", nome); err != nil {
+		id = -1
+	}
+	return
+}
+
+/*
+AdottaNoteDefault assegna all'utente con id specificato le note e le liste di UtenteDefault,
+ad esempio quelle create prima dell'introduzione degli utenti, e restituisce il numero di note assegnate e nil.
+Se l'assegnazione non riesce, restituisce 0 e ErrGestoreNonPronto se il gestore non è pronto,
+ErrUtenteNonTrovato se l'utente non esiste, oppure l'errore SQL.
+
+Chiamato subito dopo AggiungiUtente, assegna le note esistenti al primo utente creato:
+per gli utenti successivi non ci sono più note di UtenteDefault da assegnare.
+*/
+func (gn *Gestore) AdottaNoteDefault(IDUtente int64) (n int64, err error) {
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	var tot int
+	if err = gn.base.QueryRow("SELECT COUNT(*) FROM utenti WHERE id = ?;", IDUtente).Scan(&tot); err != nil {
+		return
+	}
+	if tot == 0 {
+		err = ErrUtenteNonTrovato
+		return
+	}
+
+	var tx *transazione
+	if tx, err = gn.base.Begin(); err != nil {
+		return
+	}
+
+	var res sql.Result
+	if res, err = tx.Exec("UPDATE note SET proprietario = ? WHERE proprietario = ?;", IDUtente, UtenteDefault); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if _, err = tx.Exec("UPDATE liste SET proprietario = ? WHERE proprietario = ?;", IDUtente, UtenteDefault); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	n, err = res.RowsAffected()
+	return
+}
+
+//RecuperaUtente restituisce l'utente con il nome specificato e nil.
+//Se il recupero non riesce, restituisce un utente vuoto e ErrGestoreNonPronto se il gestore non è pronto,
+//ErrUtenteNonTrovato se non c'è un utente con quel nome, oppure l'errore SQL.
+func (gn *Gestore) RecuperaUtente(nome string) (ut *Utente, err error) {
+	ut = &Utente{id: -1}
+
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	err = gn.base.QueryRow("SELECT id, nome FROM utenti WHERE nome = ?;", strings.TrimSpace(nome)).Scan(&ut.id, &ut.nome)
+
+	if err == sql.ErrNoRows {
+		ut.id = -1
+		err = ErrUtenteNonTrovato
+	}
+
+	return
+}
+
+//AggiungiLista crea una nuova lista dell'utente attivo e ne restituisce l'id e nil.
+//Se la creazione non riesce, restituisce -1 e ErrGestoreNonPronto se il gestore non è pronto,
+//ErrNotaNonValida se il nome è vuoto, oppure l'errore SQL.
+func (gn *Gestore) AggiungiLista(nome string) (id int64, err error) {
+	id = -1
+
+	if !gn.Pronto() {
+		err = ErrGestoreNonPronto
+		return
+	}
+
+	nome = strings.TrimSpace(nome)
+	if len(nome) == 0 {
+		err = ErrNotaNonValida
+		return
+	}
+
+	if id, err = gn.base.Inserisci("INSERT INTO liste (nome, proprietario) values(?, ?);", nome, gn.utente); err != nil {
+		id = -1
+	}
+	return
+}
+
+//Liste restituisce le liste a cui l'utente attivo può accedere oppure nil
+//se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) Liste() (liste []Lista) {
+	if !gn.Pronto() {
+		return nil
+	}
+
+	cond, args := gn.accessoListe("liste", PermessoLettura)
+
+	rws, err := gn.base.Query("SELECT id, nome, proprietario FROM liste WHERE "+cond+";", args...)
+	if err != nil {
+		return nil
+	}
+	defer rws.Close()
+
+	liste = make([]Lista, 0, 5)
+	for rws.Next() {
+		var ls Lista
+		if rws.Scan(&ls.id, &ls.nome, &ls.proprietario) == nil {
+			liste = append(liste, ls)
+		}
+	}
+
+	return
+}
+
+//ElencoLista restituisce le note della lista con id specificato selezionate con il filtro,
+//oppure nil se il gestore non è pronto o in caso di errori nell'interrogazione del database.
+func (gn *Gestore) ElencoLista(IDLista int64, filtro FiltroElenco) (note []Nota) {
+	if !gn.Pronto() {
+		return nil
+	}
+
+	cond, args := gn.accesso("note", PermessoLettura)
+	query, slc := filtro.condizione(gn)
+	args = append(append([]interface{}{IDLista}, args...), slc...)
+
+	return gn.seleziona("SELECT "+colonneNota+" FROM note WHERE lista = ? AND "+cond+query+";", args...)
+}
+
+//EliminaLista rimuove la lista con id specificato insieme alle sue note e restituisce nil in caso di successo.
+//Restituisce ErrGestoreNonPronto se il gestore non è pronto, ErrListaNonTrovata se la lista
+//non è accessibile, ErrPermessoNegato se l'utente attivo non ne è il proprietario, altrimenti l'errore SQL.
+func (gn *Gestore) EliminaLista(IDLista int64) (err error) {
+	if err = gn.richiediLista(IDLista, PermessoProprietario); err != nil {
+		return
+	}
+
+	var tx *transazione
+	if tx, err = gn.base.Begin(); err != nil {
+		return
+	}
+
+	if err = eliminaLista(tx, IDLista); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+//eliminaLista rimuove nella transazione la lista con id specificato e le sue condivisioni, insieme alle note
+//che contiene al momento dell'eliminazione, ai loro legami e alle loro condivisioni.
+func eliminaLista(tx *transazione, IDLista int64) (err error) {
+	const noteLista string = "SELECT id FROM note WHERE lista = ?"
+
+	if _, err = tx.Exec("DELETE FROM legami WHERE da IN ("+noteLista+") OR a IN ("+noteLista+");", IDLista, IDLista); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec("DELETE FROM condivisioni WHERE tipo = ? AND risorsa IN ("+noteLista+");", risorsaNota, IDLista); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec("DELETE FROM note WHERE lista = ?;", IDLista); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec("DELETE FROM condivisioni WHERE tipo = ? AND risorsa = ?;", risorsaLista, IDLista); err != nil {
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM liste WHERE id = ?;", IDLista)
+	return
+}
+
+//CondividiNota concede all'utente con id specificato il permesso PermessoLettura o PermessoModifica
+//sulla nota, oppure revoca la condivisione con PermessoNessuno.
+//Restituisce ErrGestoreNonPronto se il gestore non è pronto, ErrNotaNonTrovata se la nota non è accessibile,
+//ErrPermessoNegato se l'utente attivo non ne è il proprietario, ErrPermessoNonValido se il permesso non è ammesso,
+//ErrUtenteNonTrovato se l'utente non esiste, oppure l'errore SQL.
+func (gn *Gestore) CondividiNota(IDNota, IDUtente int64, p Permesso) (err error) {
+	if err = gn.richiediNota(IDNota, PermessoProprietario); err != nil {
+		return
+	}
+
+	return gn.condividi(risorsaNota, IDNota, IDUtente, p)
+}
+
+//CondividiLista concede all'utente con id specificato il permesso PermessoLettura o PermessoModifica
+//sulla lista e sulle sue note, oppure revoca la condivisione con PermessoNessuno.
+//Restituisce gli stessi errori di CondividiNota, con ErrListaNonTrovata se la lista non è accessibile.
+func (gn *Gestore) CondividiLista(IDLista, IDUtente int64, p Permesso) (err error) {
+	if err = gn.richiediLista(IDLista, PermessoProprietario); err != nil {
+		return
+	}
+
+	return gn.condividi(risorsaLista, IDLista, IDUtente, p)
+}
+
+//PermessoNota restituisce il permesso dell'utente attivo sulla nota con id specificato,
+//PermessoNessuno se la nota non esiste o non è accessibile.
+func (gn *Gestore) PermessoNota(IDNota int64) Permesso {
+	p, _ := gn.permesso("note", gn.accesso, IDNota)
+	return p
+}
+
+// condividi registra la condivisione di una risorsa con un utente.
+func (gn *Gestore) condividi(tipo string, risorsa, IDUtente int64, p Permesso) (err error) {
+	if p < PermessoNessuno || p > PermessoModifica {
+		err = ErrPermessoNonValido
+		return
+	}
+
+	var tot int
+	if err = gn.base.QueryRow("SELECT COUNT(*) FROM utenti WHERE id = ?;", IDUtente).Scan(&tot); err != nil {
+		return
+	}
+	if tot == 0 {
+		err = ErrUtenteNonTrovato
+		return
+	}
+
+	// sostituisce la condivisione precedente in una transazione, così l'utente non perde l'accesso
+	// se l'inserimento non riesce
+	var tx *transazione
+	if tx, err = gn.base.Begin(); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec("DELETE FROM condivisioni WHERE tipo = ? AND risorsa = ? AND utente = ?;", tipo, risorsa, IDUtente); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if p > PermessoNessuno {
+		if _, err = tx.Exec("INSERT INTO condivisioni (tipo, risorsa, utente, permesso) values(?, ?, ?, ?);", tipo, risorsa, IDUtente, int(p)); err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// accesso restituisce la condizione SQL e i relativi argomenti che selezionano le note della
+// tabella con l'alias specificato su cui l'utente attivo ha almeno il permesso indicato.
+// Il proprietario della nota e quello della sua lista hanno il permesso PermessoProprietario.
+func (gn *Gestore) accesso(alias string, p Permesso) (string, []interface{}) {
+	u := gn.utente
+	cond := "(" + alias + ".proprietario = ? OR " +
+		alias + ".lista IN (SELECT id FROM liste WHERE proprietario = ?) OR " +
+		alias + ".id IN (SELECT risorsa FROM condivisioni WHERE tipo = ? AND utente = ? AND permesso >= ?) OR " +
+		alias + ".lista IN (SELECT risorsa FROM condivisioni WHERE tipo = ? AND utente = ? AND permesso >= ?))"
+	return cond, []interface{}{u, u, risorsaNota, u, int(p), risorsaLista, u, int(p)}
+}
+
+// accessoListe restituisce la condizione SQL e i relativi argomenti che selezionano le liste della
+// tabella con l'alias specificato su cui l'utente attivo ha almeno il permesso indicato.
+func (gn *Gestore) accessoListe(alias string, p Permesso) (string, []interface{}) {
+	u := gn.utente
+	cond := "(" + alias + ".proprietario = ? OR " +
+		alias + ".id IN (SELECT risorsa FROM condivisioni WHERE tipo = ? AND utente = ? AND permesso >= ?))"
+	return cond, []interface{}{u, risorsaLista, u, int(p)}
+}
+
+// permesso restituisce il permesso più alto dell'utente attivo sulla riga con id specificato
+// della tabella, calcolato con la funzione di accesso indicata.
+func (gn *Gestore) permesso(tabella string, accesso func(string, Permesso) (string, []interface{}), id int64) (p Permesso, err error) {
+	if !gn.Pronto() {
+		return PermessoNessuno, ErrGestoreNonPronto
+	}
+
+	for p = PermessoProprietario; p > PermessoNessuno; p-- {
+		cond, args := accesso(tabella, p)
+		var tot int
+		if err = gn.base.QueryRow("SELECT COUNT(*) FROM "+tabella+" WHERE id = ? AND "+cond+";", append([]interface{}{id}, args...)...).Scan(&tot); err != nil {
+			return PermessoNessuno, err
+		}
+		if tot > 0 {
+			return
+		}
+	}
+
+	return PermessoNessuno, nil
+}
+
+// richiediNota restituisce nil se l'utente attivo ha almeno il permesso p sulla nota,
+// ErrNotaNonTrovata se la nota non è accessibile oppure ErrPermessoNegato se il permesso non basta.
+func (gn *Gestore) richiediNota(IDNota int64, p Permesso) error {
+	attuale, err := gn.permesso("note", gn.accesso, IDNota)
+	switch {
+	case err != nil:
+		return err
+	case attuale == PermessoNessuno:
+		return ErrNotaNonTrovata
+	case attuale < p:
+		return ErrPermessoNegato
+	}
+	return nil
+}
+
+// richiediLista restituisce nil se l'utente attivo ha almeno il permesso p sulla lista,
+// ErrListaNonTrovata se la lista non è accessibile oppure ErrPermessoNegato se il permesso non basta.
+func (gn *Gestore) richiediLista(IDLista int64, p Permesso) error {
+	attuale, err := gn.permesso("liste", gn.accessoListe, IDLista)
+	switch {
+	case err != nil:
+		return err
+	case attuale == PermessoNessuno:
+		return ErrListaNonTrovata
+	case attuale < p:
+		return ErrPermessoNegato
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//RequestIDHeader è il nome del valore dell'intestazione con l'identificativo della richiesta.
+const RequestIDHeader string = "X-Request-ID"
+
+//RedactedValue è il valore scritto nel log al posto di quello delle intestazioni riservate.
+const RedactedValue string = "[REDACTED]"
+
+//LogFormat indica il formato delle righe scritte dal middleware AccessLog.
+type LogFormat int
+
+const (
+	//LogStructured scrive una voce strutturata con log/slog.
+	LogStructured LogFormat = iota
+	//LogCommon scrive una riga nel Common Log Format.
+	LogCommon
+	//LogCombined scrive una riga nel Combined Log Format (Common con referer e user agent).
+	LogCombined
+)
+
+//DefaultRedactedHeaders restituisce le intestazioni riservate di default,
+//i cui valori non sono mai scritti nel log.
+func DefaultRedactedHeaders() []string {
+	return []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+}
+
+/*
+AccessLogOptions contiene le impostazioni del middleware AccessLog.
+
+  Format        formato delle voci, LogStructured per default
+  Logger        destinazione delle voci LogStructured, slog.Default() se nil
+  Writer        destinazione delle righe LogCommon e LogCombined, os.Stderr se nil
+  LogHeaders    se true aggiunge alle voci LogStructured tutte le intestazioni della richiesta
+  Redact        intestazioni riservate, DefaultRedactedHeaders() se nil
+  NewRequestID  ignora l'identificativo ricevuto nella richiesta e ne genera sempre uno nuovo
+*/
+type AccessLogOptions struct {
+	Format       LogFormat
+	Logger       *slog.Logger
+	Writer       io.Writer
+	LogHeaders   bool
+	Redact       []string
+	NewRequestID bool
+}
+
+// requestIDKey è la chiave dell'identificativo della richiesta nel contesto.
+type requestIDKey struct{}
+
+/*
+RequestID restituisce l'identificativo assegnato alla richiesta dal middleware AccessLog
+oppure una stringa vuota se la richiesta non ha un identificativo.
+*/
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID genera un identificativo casuale di 16 byte in formato esadecimale.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID restituisce true se l'identificativo ricevuto può essere riusato:
+// al massimo 128 caratteri ASCII stampabili senza spazi.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+AccessLog restituisce il middleware che scrive una voce nel log per ogni richiesta
+completata, con metodo, percorso, codice di stato, byte scritti e durata.
+
+Il middleware assegna a ogni richiesta un identificativo, riusando quello ricevuto
+nel valore X-Request-ID dell'intestazione se valido, lo inserisce nello stesso valore
+della risposta e lo rende disponibile ai gestori con la funzione RequestID.
+
+Nelle voci strutturate le intestazioni della richiesta sono aggiunte solo se LogHeaders è true
+e i valori di quelle riservate sono sostituiti con RedactedValue. Le voci sono scritte
+con livello Error per i codici 5xx, Warn per i codici 4xx e Info per gli altri.
+
+Per registrare tutte le richieste aggiungi il middleware per primo con il metodo Use:
+
+  sm.Use(webman.AccessLog(webman.AccessLogOptions{Format: webman.LogCombined}))
+*/
+func AccessLog(opt AccessLogOptions) Middleware {
+	if opt.Logger == nil {
+		opt.Logger = slog.Default()
+	}
+	if opt.Writer == nil {
+		opt.Writer = os.Stderr
+	}
+	if opt.Redact == nil {
+		opt.Redact = DefaultRedactedHeaders()
+	}
+	redact := make(map[string]bool, len(opt.Redact))
+	for _, h := range opt.Redact {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// assegna l'identificativo alla richiesta
+			id := RequestID(r)
+			if id == "" {
+				if id = r.Header.Get(RequestIDHeader); opt.NewRequestID || !validRequestID(id) {
+					id = newRequestID()
+				}
+				r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r)
+			d := time.Since(start)
+
+			switch opt.Format {
+			case LogCommon, LogCombined:
+				line := commonLogLine(r, rw.statusCode(), rw.size, start, opt.Format == LogCombined)
+				mu.Lock()
+				io.WriteString(opt.Writer, line)
+				mu.Unlock()
+			default:
+				attrs := []slog.Attr{
+					slog.String("request_id", id),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", rw.statusCode()),
+					slog.Int64("bytes", rw.size),
+					slog.Duration("duration", d),
+					slog.String("remote", r.RemoteAddr),
+				}
+				if opt.LogHeaders {
+					attrs = append(attrs, headerAttrs(r.Header, redact))
+				}
+				opt.Logger.LogAttrs(r.Context(), logLevel(rw.statusCode()), "richiesta", attrs...)
+			}
+		})
+	}
+}
+
+// logLevel restituisce il livello della voce del log per il codice di stato.
+func logLevel(code int) slog.Level {
+	switch {
+	case code >= 500:
+		return slog.LevelError
+	case code >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// headerAttrs restituisce il gruppo con le intestazioni della richiesta,
+// sostituendo i valori di quelle riservate.
+func headerAttrs(header http.Header, redact map[string]bool) slog.Attr {
+	attrs := make([]any, 0, len(header))
+	for k, v := range header {
+		if redact[http.CanonicalHeaderKey(k)] {
+			attrs = append(attrs, slog.String(k, RedactedValue))
+		} else {
+			attrs = append(attrs, slog.String(k, strings.Join(v, ", ")))
+		}
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// commonLogLine restituisce la riga del log nel Common Log Format
+// oppure nel Combined Log Format se combined è true.
+func commonLogLine(r *http.Request, status int, size int64, start time.Time, combined bool) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto, status, bytes)
+	if combined {
+		line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+	}
+	return line + "\n"
+}
+
+// orDash restituisce il trattino al posto di un valore vuoto.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ErrInvalidCredentials è l'errore restituito quando le credenziali di una richiesta non sono valide.
+var ErrInvalidCredentials error = errors.New("invalid credentials")
+
+//ErrInvalidUsersFile è l'errore restituito quando una riga del file degli utenti non è valida.
+var ErrInvalidUsersFile error = errors.New("invalid users file")
+
+// ===== Tipo Principal =====
+
+/*
+Principal rappresenta l'utente o il client autenticato di una richiesta.
+
+  Name    nome dell'utente o del client
+  Scheme  schema con cui è stato autenticato: "basic", "session" o "bearer" per gli autenticatori di webman
+*/
+type Principal struct {
+	Name   string
+	Scheme string
+}
+
+//String restituisce il nome del principal.
+func (p *Principal) String() string {
+	return p.Name
+}
+
+/*
+Authenticator riconosce il principal di una richiesta da un tipo di credenziali.
+
+Authenticate restituisce nil e nil se la richiesta non contiene credenziali del suo tipo,
+così il middleware prova l'autenticatore successivo, oppure un errore se le credenziali non sono valide.
+Challenge restituisce il valore "WWW-Authenticate" delle risposte 401, vuoto se lo schema non ne prevede uno
+(ad esempio per i cookie di sessione).
+*/
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	Challenge() string
+}
+
+// authState contiene il principal della richiesta e i valori "WWW-Authenticate" degli autenticatori.
+type authState struct {
+	principal  *Principal
+	challenges []string
+}
+
+// authKey è la chiave dello stato di autenticazione nel contesto della richiesta.
+type authKey struct{}
+
+//RequestPrincipal restituisce il principal autenticato della richiesta dal middleware Authenticate, altrimenti nil.
+func RequestPrincipal(r *http.Request) *Principal {
+	st, _ := r.Context().Value(authKey{}).(*authState)
+	if st == nil {
+		return nil
+	}
+	return st.principal
+}
+
+/*
+Authenticate restituisce un middleware che riconosce il principal delle richieste con gli autenticatori specificati,
+provati nell'ordine, e lo rende disponibile ai gestori con la funzione RequestPrincipal.
+
+Il middleware non rifiuta le richieste anonime o con credenziali non valide, che sono solo scritte nel log:
+i percorsi riservati vanno avvolti con il middleware RequireAuth.
+
+  sm.Use(sm.Authenticate(sessioni, utenti, token))
+  sm.EnlistMethodOK(http.MethodGet, "/note", webman.Chain(hler, sm.RequireAuth("/accedi")))
+*/
+func (sm *ServerManager) Authenticate(auths ...Authenticator) Middleware {
+	var challenges []string
+	for _, a := range auths {
+		if c := a.Challenge(); c != "" {
+			challenges = append(challenges, c)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			st := &authState{challenges: challenges}
+			for _, a := range auths {
+				p, err := a.Authenticate(r)
+				if err != nil {
+					sm.log.Printf("AUTENTICAZIONE NON RIUSCITA [%s]: %v\n", RequestID(r), err)
+					continue
+				}
+				if p != nil {
+					st.principal = p
+					break
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, st)))
+		})
+	}
+}
+
+/*
+RequireAuth restituisce un middleware che affida la richiesta al gestore solo se ha un principal autenticato.
+
+Le richieste anonime ricevono la risposta 401 Unauthorized con i valori "WWW-Authenticate"
+degli autenticatori del middleware Authenticate, inviata con il metodo ReplyStatus.
+Se loginPath non è vuoto, le richieste che preferiscono una pagina HTML e non contengono il valore "Authorization"
+sono invece reindirizzate con il codice 303 alla pagina di accesso, con il parametro next contenente
+il percorso richiesto per le richieste GET.
+
+Va associato ai percorsi riservati con la funzione Chain, dopo aver aggiunto Authenticate con Use.
+*/
+func (sm *ServerManager) RequireAuth(loginPath string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RequestPrincipal(r) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept")
+			if (loginPath != "") && (r.Header.Get("Authorization") == "") &&
+				(Negotiate(r, "text/html", "application/json", ProblemMediaType) == "text/html") {
+				target := loginPath
+				if r.Method == http.MethodGet {
+					target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+				}
+				http.Redirect(w, r, target, http.StatusSeeOther)
+				return
+			}
+			if st, _ := r.Context().Value(authKey{}).(*authState); st != nil {
+				for _, c := range st.challenges {
+					w.Header().Add("WWW-Authenticate", c)
+				}
+			}
+			sm.ReplyStatus(http.StatusUnauthorized, "Autenticazione richiesta.", w, r)
+		})
+	}
+}
+
+// ===== Tipo BasicAuth =====
+
+// dummyHash è un hash bcrypt confrontato per i nomi utente sconosciuti,
+// così la durata della verifica non rivela se l'utente esiste.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte(NewToken()), bcrypt.DefaultCost)
+	return h
+})
+
+//HashPassword restituisce l'hash bcrypt della password specificata per il file degli utenti di BasicAuth.
+func HashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+/*
+BasicAuth autentica le richieste con lo schema HTTP Basic (RFC 7617)
+confrontando la password con l'hash bcrypt dell'utente.
+
+Il metodo Verify permette di usare gli stessi utenti in una pagina di accesso con i cookie di sessione.
+Le credenziali Basic sono inviate in chiaro a ogni richiesta: usa questo schema solo con HTTPS.
+*/
+type BasicAuth struct {
+	realm string
+	users map[string][]byte
+}
+
+//NewBasicAuth restituisce un BasicAuth con il realm specificato e gli utenti con il relativo hash bcrypt.
+func NewBasicAuth(realm string, users map[string]string) *BasicAuth {
+	ba := &BasicAuth{realm: realm, users: make(map[string][]byte, len(users))}
+	for name, hash := range users {
+		ba.users[name] = []byte(hash)
+	}
+	return ba
+}
+
+/*
+LoadBasicAuth restituisce un BasicAuth con il realm specificato e gli utenti letti dal file indicato.
+
+Ogni riga del file contiene il nome dell'utente e l'hash bcrypt della password separati da due punti,
+come nei file creati da htpasswd -B; le righe vuote e quelle che iniziano con # sono ignorate.
+
+  # utenti di RicordaLista
+  mario:$2a$10$...
+
+Se una riga non è valida restituisce l'errore ErrInvalidUsersFile con il numero della riga.
+*/
+func LoadBasicAuth(realm string, path string) (*BasicAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || (name == "") {
+			return nil, fmt.Errorf("%w: %s riga %d", ErrInvalidUsersFile, path, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%w: %s riga %d: %v", ErrInvalidUsersFile, path, n, err)
+		}
+		users[name] = hash
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	return NewBasicAuth(realm, users), nil
+}
+
+//Verify restituisce true se la password corrisponde a quella dell'utente specificato.
+func (ba *BasicAuth) Verify(name, password string) bool {
+	hash, ok := ba.users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+//Users restituisce i nomi degli utenti in ordine alfabetico.
+func (ba *BasicAuth) Users() []string {
+	names := make([]string, 0, len(ba.users))
+	for name := range ba.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Authenticate restituisce il principal delle credenziali Basic della richiesta, se presenti.
+func (ba *BasicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if !ba.Verify(name, password) {
+		return nil, fmt.Errorf("%w: utente %q", ErrInvalidCredentials, name)
+	}
+	return &Principal{Name: name, Scheme: "basic"}, nil
+}
+
+//Challenge restituisce lo schema Basic con il realm.
+func (ba *BasicAuth) Challenge() string {
+	return `Basic realm="` + strings.ReplaceAll(ba.realm, `"`, "") + `", charset="UTF-8"`
+}
+
+// ===== Tipo TokenAuth =====
+
+/*
+TokenAuth autentica le richieste con lo schema Bearer (RFC 6750), cioè con il valore
+"Authorization: Bearer <token>" dell'intestazione, per i client delle API.
+
+Dei token è conservato solo l'hash SHA-256: i token devono essere casuali e lunghi, ad esempio creati con NewToken.
+*/
+type TokenAuth struct {
+	mu     sync.RWMutex
+	tokens map[[sha256.Size]byte]string
+}
+
+//NewToken restituisce un nuovo token casuale di 32 byte in formato base64.
+func NewToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//NewTokenAuth restituisce un TokenAuth con i token specificati, ognuno associato al nome del client.
+func NewTokenAuth(tokens map[string]string) *TokenAuth {
+	ta := &TokenAuth{tokens: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, name := range tokens {
+		ta.Add(token, name)
+	}
+	return ta
+}
+
+//Add associa il token specificato al nome del client.
+func (ta *TokenAuth) Add(token, name string) {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	ta.tokens[sha256.Sum256([]byte(token))] = name
+}
+
+//Revoke elimina il token specificato.
+func (ta *TokenAuth) Revoke(token string) {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	delete(ta.tokens, sha256.Sum256([]byte(token)))
+}
+
+//Authenticate restituisce il principal del token Bearer della richiesta, se presente.
+func (ta *TokenAuth) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+	ta.mu.RLock()
+	name, ok := ta.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	ta.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: token sconosciuto", ErrInvalidCredentials)
+	}
+	return &Principal{Name: name, Scheme: "bearer"}, nil
+}
+
+//Challenge restituisce lo schema Bearer.
+func (ta *TokenAuth) Challenge() string {
+	return "Bearer"
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultCompressMinSize è la dimensione minima in byte di default di una risposta compressa dal middleware Compress.
+const DefaultCompressMinSize int = 1024
+
+//DefaultCompressTypes restituisce i tipi di contenuto compressi per default dal middleware Compress:
+//testo, JSON, XML, JavaScript e SVG. I tipi già compressi come immagini PNG e JPEG, video e archivi sono esclusi.
+func DefaultCompressTypes() []string {
+	return []string{
+		"text/*",
+		"application/json", "application/problem+json", "application/x-ndjson",
+		"application/xml", "application/javascript", "application/wasm",
+		"image/svg+xml",
+	}
+}
+
+/*
+CompressOptions contiene le opzioni del middleware Compress.
+
+  MinSize  dimensione minima in byte delle risposte compresse, DefaultCompressMinSize se minore o uguale a zero
+  Types    tipi di contenuto compressi, anche nella forma tipo/*, DefaultCompressTypes() se nil
+  Level    livello di compressione da 1 (più veloce) a 9 (più compatto), quello di default se 0
+*/
+type CompressOptions struct {
+	MinSize int
+	Types   []string
+	Level   int
+}
+
+// compressor contiene le opzioni del middleware e i compressori riutilizzabili.
+type compressor struct {
+	minSize int
+	types   []string
+	level   int
+	gzips   sync.Pool
+	zlibs   sync.Pool
+}
+
+// resettableWriter è un compressore che può essere riutilizzato con un'altra destinazione.
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// newWriter restituisce un compressore per la codifica specificata che scrive su w.
+func (c *compressor) newWriter(encoding string, w io.Writer) resettableWriter {
+	pool := &c.gzips
+	if encoding == "deflate" {
+		pool = &c.zlibs
+	}
+	if cw, ok := pool.Get().(resettableWriter); ok {
+		cw.Reset(w)
+		return cw
+	}
+	// il livello è verificato da Compress
+	if encoding == "deflate" {
+		zw, _ := zlib.NewWriterLevel(w, c.level)
+		return zw
+	}
+	gw, _ := gzip.NewWriterLevel(w, c.level)
+	return gw
+}
+
+// releaseWriter chiude il compressore e lo rende disponibile per un'altra risposta.
+func (c *compressor) releaseWriter(encoding string, cw resettableWriter) error {
+	err := cw.Close()
+	cw.Reset(io.Discard)
+	if encoding == "deflate" {
+		c.zlibs.Put(cw)
+	} else {
+		c.gzips.Put(cw)
+	}
+	return err
+}
+
+// compressible restituisce true se il tipo di contenuto è fra quelli da comprimere.
+func (c *compressor) compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mt, prefix+"/") {
+				return true
+			}
+		} else if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding restituisce la codifica scelta fra gzip e deflate secondo il valore Accept-Encoding,
+// preferendo gzip a parità di peso, oppure una stringa vuota se la richiesta non ne accetta nessuna.
+func negotiateEncoding(acceptEncoding string) string {
+	weights := make(map[string]float64, 3)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		weights[name] = q
+	}
+
+	weight := func(name string) float64 {
+		if q, ok := weights[name]; ok {
+			return q
+		}
+		return weights["*"]
+	}
+	gzipQ, deflateQ := weight("gzip"), weight("deflate")
+	switch {
+	case (gzipQ > 0) && (gzipQ >= deflateQ):
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// ===== Tipo compressWriter =====
+
+// compressWriter raccoglie l'inizio della risposta fino alla dimensione minima
+// e poi decide se inviarla compressa o così com'è.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compressor
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	cw       resettableWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	switch {
+	case w.decided:
+		w.ResponseWriter.WriteHeader(code)
+	case code < 200:
+		// le risposte informative sono inviate subito
+		w.ResponseWriter.WriteHeader(code)
+	case w.status == 0:
+		w.status = code
+		if !bodyAllowed(code) {
+			w.decide(false)
+		}
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.c.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush invia quanto raccolto, compresso se il tipo di contenuto lo permette anche sotto la dimensione minima.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.cw != nil {
+		w.cw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap restituisce l'http.ResponseWriter originale per http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowed restituisce true se una risposta con il codice specificato può avere un corpo da comprimere.
+func bodyAllowed(code int) bool {
+	return (code >= 200) && (code != http.StatusNoContent) && (code != http.StatusNotModified) && (code != http.StatusPartialContent)
+}
+
+// decide invia l'intestazione e il corpo raccolto, compresso se compress è true
+// e la risposta soddisfa le condizioni del middleware.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if (h.Get("Content-Type") == "") && (len(w.buf) > 0) {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	compress = compress && bodyAllowed(w.status) &&
+		(h.Get("Content-Encoding") == "") && (h.Get("Content-Range") == "") &&
+		w.c.compressible(h.Get("Content-Type"))
+	if compress {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// la rappresentazione compressa non è identica byte per byte: l'ETag forte diventa debole
+		if etag := h.Get("ETag"); (etag != "") && !etagWeak(etag) {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.cw = w.c.newWriter(w.encoding, w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.cw != nil {
+		_, err = w.cw.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// finish completa la risposta: invia il corpo più piccolo della dimensione minima senza compressione
+// oppure chiude il compressore.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		if (w.status == 0) && (len(w.buf) == 0) {
+			// il gestore non ha scritto niente
+			return
+		}
+		w.decide(false)
+	}
+	if w.cw != nil {
+		w.c.releaseWriter(w.encoding, w.cw)
+		w.cw = nil
+	}
+}
+
+/*
+Compress restituisce un middleware che comprime le risposte con gzip o deflate,
+secondo il valore Accept-Encoding della richiesta (a parità di peso è preferito gzip).
+
+Sono compresse solo le risposte con un tipo di contenuto fra quelli delle opzioni e con almeno MinSize byte;
+se il gestore non imposta "Content-Type", il tipo è ricavato dai primi byte con http.DetectContentType.
+Non sono compresse le risposte che hanno già il valore "Content-Encoding", le risposte parziali (206)
+e quelle senza corpo (204 e 304), quindi i file già compressi come le immagini PNG non sono compressi di nuovo.
+
+A tutte le risposte è aggiunto il valore "Vary: Accept-Encoding" per le cache intermedie.
+Nelle risposte compresse "Content-Length" è eliminato e un ETag forte diventa debole:
+per gli aggiornamenti condizionati con If-Match, che richiedono il confronto forte, il client
+deve usare l'ETag di una risposta non compressa.
+La compressione brotli non è disponibile perché la libreria standard di Go non la implementa.
+
+Il middleware Compress deve precedere ETag nell'elenco di Use, così l'ETag è calcolato sul corpo non compresso:
+
+  sm.Use(webman.Compress(webman.CompressOptions{}), webman.ETag(true))
+
+Il metodo genera un panic se il livello di compressione non è valido.
+*/
+func Compress(opt CompressOptions) Middleware {
+	c := &compressor{minSize: opt.MinSize, types: opt.Types, level: opt.Level}
+	if c.minSize <= 0 {
+		c.minSize = DefaultCompressMinSize
+	}
+	if c.types == nil {
+		c.types = DefaultCompressTypes()
+	}
+	if c.level == 0 {
+		c.level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, c.level); err != nil {
+		panic(err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+			defer cw.finish()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//DefaultETagBufferSize è la dimensione massima in byte della risposta su cui il middleware ETag calcola l'ETag.
+const DefaultETagBufferSize int = 4 << 20
+
+/*
+MakeETag restituisce il valore ETag dell'intestazione per la versione specificata,
+racchiusa fra virgolette e preceduta da W/ se weak è true.
+
+Un ETag forte (weak false) indica che due risposte con lo stesso ETag sono identiche byte per byte,
+uno debole che sono equivalenti, ad esempio la stessa pagina con una diversa compressione.
+Le virgolette nella versione sono rimosse.
+*/
+func MakeETag(version string, weak bool) string {
+	etag := `"` + strings.ReplaceAll(version, `"`, "") + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+//HashETag restituisce un ETag calcolato con SHA-256 dai dati specificati.
+func HashETag(data []byte, weak bool) string {
+	sum := sha256.Sum256(data)
+	return MakeETag(base64.RawURLEncoding.EncodeToString(sum[:18]), weak)
+}
+
+// etagWeak restituisce true se l'ETag è debole.
+func etagWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// etagMatch restituisce true se l'elenco del valore If-Match o If-None-Match contiene l'ETag specificato.
+// Con strong true gli ETag deboli non corrispondono mai (confronto forte, RFC 9110 sezione 8.8.3.2).
+// Il valore "*" corrisponde a qualunque ETag non vuoto.
+func etagMatch(list string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && etagWeak(etag) {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && etagWeak(candidate) {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionResult è il risultato della valutazione delle condizioni di una richiesta.
+type conditionResult int
+
+const (
+	conditionProceed conditionResult = iota
+	conditionNotModified
+	conditionFailed
+)
+
+// evaluateConditions valuta le condizioni della richiesta nell'ordine previsto dalla RFC 9110, sezione 13.2.2.
+func evaluateConditions(r *http.Request, etag string, modified time.Time) conditionResult {
+	safe := (r.Method == http.MethodGet) || (r.Method == http.MethodHead)
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, true) {
+			return conditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); (ius != "") && !modified.IsZero() {
+		if t, err := http.ParseTime(ius); (err == nil) && modified.Truncate(time.Second).After(t) {
+			return conditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag, false) {
+			if safe {
+				return conditionNotModified
+			}
+			return conditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); safe && (ims != "") && !modified.IsZero() {
+		if t, err := http.ParseTime(ims); (err == nil) && !modified.Truncate(time.Second).After(t) {
+			return conditionNotModified
+		}
+	}
+	return conditionProceed
+}
+
+// writeNotModified risponde con il codice 304 eliminando le intestazioni relative al corpo.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+		h.Del(k)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+/*
+CheckConditions verifica le condizioni della richiesta rispetto alla versione attuale della risorsa,
+indicata dall'ETag (ad esempio restituito da MakeETag o HashETag) e dalla data di ultima modifica;
+un ETag vuoto o una data zero indicano che il valore non è disponibile.
+
+Le condizioni sono valutate nell'ordine previsto dalla RFC 9110:
+
+  If-Match            con confronto forte, se non corrisponde la risposta è 412 PreconditionFailed
+  If-Unmodified-Since se If-Match è assente e la risorsa è stata modificata dopo la data, 412
+  If-None-Match       se corrisponde, 304 NotModified per GET e HEAD, 412 per gli altri metodi
+  If-Modified-Since   se If-None-Match è assente, per GET e HEAD 304 se la risorsa non è stata modificata dopo la data
+
+Per le richieste GET e HEAD i valori "ETag" e "Last-Modified" sono impostati nell'intestazione della risposta.
+Restituisce true se il gestore deve continuare con la risposta, false se ha già risposto con il codice 304 o 412.
+
+  etag := webman.HashETag(dati, false)
+  if !webman.CheckConditions(r, etag, time.Time{}, w) {
+    return
+  }
+*/
+func CheckConditions(r *http.Request, etag string, modified time.Time, w http.ResponseWriter) bool {
+	if (r.Method == http.MethodGet) || (r.Method == http.MethodHead) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	switch evaluateConditions(r, etag, modified) {
+	case conditionNotModified:
+		writeNotModified(w)
+		return false
+	case conditionFailed:
+		WriteProblem(NewProblem(http.StatusPreconditionFailed, "La risorsa è stata modificata."), w, r)
+		return false
+	}
+	return true
+}
+
+// ===== Tipo etagWriter =====
+
+// etagWriter raccoglie la risposta di un gestore per calcolarne l'ETag.
+// Se il gestore chiama Flush o la risposta supera la dimensione massima, invia quanto raccolto
+// e prosegue senza ETag.
+type etagWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	max         int
+	status      int
+	passThrough bool
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.passThrough {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if (ew.status == 0) && (code >= 200) {
+		ew.status = code
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if !ew.passThrough && (ew.buf.Len()+len(b) > ew.max) {
+		ew.startPassThrough()
+	}
+	if ew.passThrough {
+		return ew.ResponseWriter.Write(b)
+	}
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	return ew.buf.Write(b)
+}
+
+// Flush invia la risposta raccolta e le successive scritture senza calcolare l'ETag.
+func (ew *etagWriter) Flush() {
+	if !ew.passThrough {
+		ew.startPassThrough()
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap restituisce l'http.ResponseWriter originale per http.ResponseController.
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// startPassThrough invia l'intestazione e il corpo raccolti e smette di raccogliere la risposta.
+func (ew *etagWriter) startPassThrough() {
+	ew.passThrough = true
+	if ew.status != 0 {
+		ew.ResponseWriter.WriteHeader(ew.status)
+	}
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+	ew.buf = bytes.Buffer{}
+}
+
+// finish completa la risposta, con il codice 304 o 412 se le condizioni della richiesta lo prevedono.
+func (ew *etagWriter) finish(r *http.Request, weak bool) {
+	if ew.passThrough {
+		return
+	}
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	h := ew.Header()
+	// le risposte HEAD senza corpo non permettono di calcolare lo stesso ETag della richiesta GET
+	headOnly := (r.Method == http.MethodHead) && (ew.buf.Len() == 0) && (h.Get("ETag") == "")
+	if (ew.status == http.StatusOK) && !headOnly {
+		etag := h.Get("ETag")
+		if etag == "" {
+			etag = HashETag(ew.buf.Bytes(), weak)
+			h.Set("ETag", etag)
+		}
+		var modified time.Time
+		if lm := h.Get("Last-Modified"); lm != "" {
+			modified, _ = http.ParseTime(lm)
+		}
+		switch evaluateConditions(r, etag, modified) {
+		case conditionNotModified:
+			writeNotModified(ew.ResponseWriter)
+			return
+		case conditionFailed:
+			for _, k := range []string{"ETag", "Last-Modified", "Content-Length"} {
+				h.Del(k)
+			}
+			WriteProblem(NewProblem(http.StatusPreconditionFailed, "La risorsa è stata modificata."), ew.ResponseWriter, r)
+			return
+		}
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
+
+/*
+ETag restituisce un middleware che aggiunge l'ETag alle risposte 200 delle richieste GET e HEAD
+e risponde con il codice 304 o 412 secondo le condizioni della richiesta, come CheckConditions.
+
+L'ETag è calcolato con HashETag dal corpo della risposta, debole se weak è true, a meno che
+il gestore non lo abbia già impostato nell'intestazione, ad esempio con MakeETag da un numero di versione.
+Se il gestore imposta anche "Last-Modified", la data è usata per If-Modified-Since e If-Unmodified-Since.
+
+Il middleware raccoglie in memoria le risposte fino a DefaultETagBufferSize byte: le risposte più grandi
+e quelle in cui il gestore chiama Flush sono inviate man mano senza ETag.
+Le richieste con altri metodi sono affidate al gestore senza modifiche: per gli aggiornamenti condizionati
+il gestore deve verificare If-Match con CheckConditions.
+*/
+func ETag(weak bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method != http.MethodGet) && (r.Method != http.MethodHead) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &etagWriter{ResponseWriter: w, max: DefaultETagBufferSize}
+			next.ServeHTTP(ew, r)
+			ew.finish(r, weak)
+		})
+	}
+}
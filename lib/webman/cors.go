@@ -0,0 +1,222 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ErrInvalidOrigin è l'errore usato nel panic quando un'origine della politica CORS non è valida.
+var ErrInvalidOrigin error = errors.New("invalid origin")
+
+//DefaultCORSHeaders restituisce le intestazioni che le richieste di altre origini possono inviare per default.
+func DefaultCORSHeaders() []string {
+	return []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", RequestIDHeader}
+}
+
+/*
+CORSOptions contiene la politica CORS (Cross-Origin Resource Sharing) del middleware CORS.
+
+  AllowedOrigins    origini ammesse, ad esempio "https://app.esempio.it"; "*" ammette tutte le origini
+                    e il carattere * in un'origine corrisponde a una parte senza slash, ad esempio
+                    "http://localhost:*" o "https://*.esempio.it"
+  AllowedMethods    metodi ammessi, se nil tutti quelli associati al percorso richiesto
+  AllowedHeaders    intestazioni che la richiesta può inviare, DefaultCORSHeaders() se nil; "*" le ammette tutte
+  ExposedHeaders    intestazioni della risposta leggibili dallo script, oltre a quelle sempre leggibili
+  AllowCredentials  se true la richiesta può inviare cookie e credenziali HTTP
+  MaxAge            durata per cui il browser può riutilizzare la risposta alla verifica preliminare, se maggiore di zero
+
+Le origini sono confrontate senza distinzione fra maiuscole e minuscole.
+*/
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// corsPolicy contiene la politica CORS preparata per le verifiche.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     []string
+	methods     []string
+	anyHeader   bool
+	headers     map[string]bool
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+// allowOrigin restituisce true se l'origine specificata è ammessa.
+func (cp *corsPolicy) allowOrigin(origin string) bool {
+	if cp.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range cp.origins {
+		if ok, _ := path.Match(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders restituisce true se tutte le intestazioni dell'elenco specificato sono ammesse.
+func (cp *corsPolicy) allowHeaders(list string) bool {
+	if cp.anyHeader {
+		return true
+	}
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); (h != "") && !cp.headers[strings.ToLower(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin imposta nell'intestazione della risposta l'origine ammessa e le credenziali.
+func (cp *corsPolicy) setOrigin(h http.Header, origin string) {
+	if cp.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cp.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight risponde alla verifica preliminare di una richiesta di un'altra origine.
+// Se l'origine, il metodo o le intestazioni non sono ammessi, la risposta non contiene
+// i valori Access-Control-Allow e il browser non invia la richiesta.
+func (sm *ServerManager) preflight(cp *corsPolicy, action serverAction, w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	h.Set("Allow", action.allowedMethods())
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	requested := r.Header.Get("Access-Control-Request-Headers")
+
+	allowed := cp.allowOrigin(origin) && cp.allowHeaders(requested)
+	if allowed {
+		_, allowed = action.methodAction(method)
+	}
+	if allowed && (cp.methods != nil) {
+		allowed = false
+		for _, m := range cp.methods {
+			if m == method {
+				allowed = true
+				break
+			}
+		}
+	}
+	if allowed {
+		cp.setOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", method)
+		if requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		if cp.maxAge != "" {
+			h.Set("Access-Control-Max-Age", cp.maxAge)
+		}
+	} else {
+		sm.log.Printf("CORS: VERIFICA NON SUPERATA [%s]: %s %s %s\n", RequestID(r), origin, method, r.URL.Path)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+CORS restituisce un middleware che applica la politica CORS specificata alle richieste di altre origini,
+cioè con il valore "Origin" nell'intestazione.
+
+Le verifiche preliminari (richieste OPTIONS con "Access-Control-Request-Method") ricevono la risposta 204
+senza passare ai gestori: il metodo richiesto è ammesso solo se il percorso ha un gestore per quel metodo
+(come per il valore "Allow") e, se specificati, è fra i metodi della politica. I percorsi senza gestore ricevono la risposta 404.
+
+Alle altre richieste di un'origine ammessa sono aggiunti i valori Access-Control-Allow-Origin
+ed eventualmente Access-Control-Allow-Credentials e Access-Control-Expose-Headers, anche nelle risposte di errore,
+così lo script può leggere i dettagli del problema. Le richieste di origini non ammesse sono servite senza
+questi valori e il browser impedisce allo script di leggere la risposta.
+
+Il middleware va aggiunto con il metodo Use dello stesso ServerManager:
+
+  sm.Use(sm.CORS(webman.CORSOptions{AllowedOrigins: []string{"http://localhost:*"}}))
+
+Il metodo genera un panic con l'errore ErrInvalidOrigin se un'origine non è valida
+o se AllowCredentials è true con l'origine "*", che ammetterebbe le credenziali di qualunque sito.
+*/
+func (sm *ServerManager) CORS(opt CORSOptions) Middleware {
+	cp := &corsPolicy{methods: opt.AllowedMethods, credentials: opt.AllowCredentials}
+	for _, o := range opt.AllowedOrigins {
+		if o == "*" {
+			cp.anyOrigin = true
+			continue
+		}
+		if _, err := path.Match(o, ""); (err != nil) || strings.HasSuffix(o, "/") {
+			panic(fmt.Errorf("%w: %s", ErrInvalidOrigin, o))
+		}
+		cp.origins = append(cp.origins, strings.ToLower(o))
+	}
+	if cp.anyOrigin && cp.credentials {
+		panic(fmt.Errorf("%w: * con AllowCredentials", ErrInvalidOrigin))
+	}
+
+	headers := opt.AllowedHeaders
+	if headers == nil {
+		headers = DefaultCORSHeaders()
+	}
+	cp.headers = make(map[string]bool, len(headers))
+	for _, h := range headers {
+		if h == "*" {
+			cp.anyHeader = true
+		}
+		cp.headers[strings.ToLower(h)] = true
+	}
+	cp.exposed = strings.Join(opt.ExposedHeaders, ", ")
+	if opt.MaxAge > 0 {
+		cp.maxAge = strconv.Itoa(int(opt.MaxAge / time.Second))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if (r.Method == http.MethodOptions) && (r.Header.Get("Access-Control-Request-Method") != "") {
+				if action, _ := sm.route(r); action.statusReply {
+					next.ServeHTTP(w, r)
+				} else {
+					sm.preflight(cp, action, w, r)
+				}
+				return
+			}
+
+			h := w.Header()
+			if !cp.anyOrigin {
+				h.Add("Vary", "Origin")
+			}
+			if cp.allowOrigin(origin) {
+				cp.setOrigin(h, origin)
+				if cp.exposed != "" {
+					h.Set("Access-Control-Expose-Headers", cp.exposed)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+//DefaultCSRFCookie è il nome di default del cookie con il segreto CSRF.
+const DefaultCSRFCookie string = "csrf"
+
+//DefaultCSRFField è il nome di default del campo dei moduli con il token CSRF.
+const DefaultCSRFField string = "csrf_token"
+
+//DefaultCSRFHeader è il nome di default del valore dell'intestazione con il token CSRF delle richieste degli script.
+const DefaultCSRFHeader string = "X-CSRF-Token"
+
+//ErrCSRF è l'errore scritto nel log quando una richiesta è rifiutata dal middleware CSRF.
+var ErrCSRF error = errors.New("cross-site request forgery")
+
+// csrfSecretSize è la dimensione in byte del segreto CSRF.
+const csrfSecretSize int = 32
+
+/*
+CSRFOptions contiene le impostazioni del middleware CSRF.
+
+  CookieName      nome del cookie con il segreto, DefaultCSRFCookie se vuoto
+  FieldName       nome del campo dei moduli con il token, DefaultCSRFField se vuoto
+  HeaderName      nome del valore dell'intestazione con il token, DefaultCSRFHeader se vuoto
+  TrustedOrigins  origini di altri siti da cui sono ammesse le richieste, con il carattere * come in CORSOptions
+  Secure          se true il cookie è inviato solo con HTTPS, come avviene comunque per le richieste su TLS
+  MaxBytes        dimensione massima del modulo letto per cercare il token, DefaultMaxBodySize se minore o uguale a zero
+*/
+type CSRFOptions struct {
+	CookieName     string
+	FieldName      string
+	HeaderName     string
+	TrustedOrigins []string
+	Secure         bool
+	MaxBytes       int64
+}
+
+// csrfState contiene il segreto CSRF del client e il nome del campo dei moduli con il token.
+type csrfState struct {
+	secret []byte
+	field  string
+}
+
+// csrfKey è la chiave dello stato CSRF nel contesto della richiesta.
+type csrfKey struct{}
+
+// requestCSRF restituisce lo stato CSRF della richiesta, nil se il middleware CSRF non è presente.
+func requestCSRF(r *http.Request) *csrfState {
+	st, _ := r.Context().Value(csrfKey{}).(*csrfState)
+	return st
+}
+
+/*
+CSRFToken restituisce il token CSRF da inviare con le richieste che cambiano lo stato,
+nel campo dei moduli o nel valore dell'intestazione indicati nelle opzioni del middleware CSRF.
+Restituisce una stringa vuota se il middleware CSRF non è presente.
+
+Ogni chiamata restituisce un token diverso, mascherato con byte casuali: il segreto non compare mai
+due volte uguale nelle pagine, quindi non può essere ricavato confrontando le dimensioni
+delle risposte compresse (attacco BREACH).
+*/
+func CSRFToken(r *http.Request) string {
+	st := requestCSRF(r)
+	if st == nil {
+		return ""
+	}
+	token := make([]byte, 2*csrfSecretSize)
+	rand.Read(token[:csrfSecretSize])
+	subtle.XORBytes(token[csrfSecretSize:], token[:csrfSecretSize], st.secret)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+/*
+CSRFField restituisce il campo nascosto con il token CSRF da inserire nei moduli HTML,
+vuoto se il middleware CSRF non è presente. Può essere aggiunta alle funzioni dei template:
+
+  t.Funcs(template.FuncMap{"csrf": func() template.HTML { return webman.CSRFField(r) }})
+
+  <form action="/note" method="POST">{{csrf}} ... </form>
+*/
+func CSRFField(r *http.Request) template.HTML {
+	st := requestCSRF(r)
+	if st == nil {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(st.field) +
+		`" value="` + CSRFToken(r) + `">`)
+}
+
+// validToken restituisce true se il token mascherato corrisponde al segreto.
+func (st *csrfState) validToken(token string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if (err != nil) || (len(data) != 2*csrfSecretSize) {
+		return false
+	}
+	subtle.XORBytes(data[csrfSecretSize:], data[csrfSecretSize:], data[:csrfSecretSize])
+	return subtle.ConstantTimeCompare(data[csrfSecretSize:], st.secret) == 1
+}
+
+// safeMethod restituisce true per i metodi che non devono cambiare lo stato del server.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// checkOrigin verifica che la richiesta provenga dallo stesso sito o da un'origine fidata
+// secondo i valori Sec-Fetch-Site e Origin inviati dal browser.
+func checkOrigin(r *http.Request, trusted *corsPolicy) error {
+	origin := r.Header.Get("Origin")
+	switch site := r.Header.Get("Sec-Fetch-Site"); site {
+	case "", "same-origin", "none":
+	default:
+		if (origin == "") || !trusted.allowOrigin(origin) {
+			return fmt.Errorf("%w: Sec-Fetch-Site %s da %q", ErrCSRF, site, origin)
+		}
+	}
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if (err != nil) || (u.Host == "") {
+		return fmt.Errorf("%w: origine %q non valida", ErrCSRF, origin)
+	}
+	if !strings.EqualFold(u.Host, r.Host) && !trusted.allowOrigin(origin) {
+		return fmt.Errorf("%w: origine %q", ErrCSRF, origin)
+	}
+	return nil
+}
+
+// formToken restituisce il token del campo del modulo se la richiesta contiene un modulo HTML,
+// oppure l'errore di lettura del corpo.
+func formToken(st *csrfState, maxBytes int64, w http.ResponseWriter, r *http.Request) (string, error) {
+	mt, err := requestMediaType(r)
+	if err != nil {
+		return "", nil
+	}
+	switch mt {
+	case "application/x-www-form-urlencoded":
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		err = r.ParseForm()
+	case "multipart/form-data":
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		err = r.ParseMultipartForm(maxBytes)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", bodyError(err)
+	}
+	return r.PostForm.Get(st.field), nil
+}
+
+/*
+CSRF restituisce un middleware che protegge le richieste che cambiano lo stato (POST, PUT, PATCH, DELETE)
+dagli attacchi Cross-Site Request Forgery, cioè dai moduli e dagli script di altri siti che inviano
+richieste con i cookie dell'utente.
+
+Il middleware imposta in un cookie HttpOnly un segreto casuale per ogni client e verifica le richieste
+in due modi:
+
+  - il browser deve indicare che la richiesta proviene dallo stesso sito: il valore "Sec-Fetch-Site",
+    se presente, deve essere same-origin o none e l'host del valore "Origin", se presente,
+    deve coincidere con quello della richiesta, a meno che l'origine sia fra TrustedOrigins;
+  - la richiesta deve contenere un token creato con CSRFToken o CSRFField per lo stesso segreto,
+    nel valore dell'intestazione HeaderName, usato dagli script, o nel campo FieldName dei moduli HTML.
+
+Le richieste rifiutate ricevono la risposta 403 Forbidden inviata con il metodo ReplyStatus.
+Il campo del token è ignorato da DecodeForm, quindi non va dichiarato nelle struct dei moduli.
+
+Le richieste autenticate con lo schema Bearer non sono verificate, perché il browser non invia
+il token in automatico: il middleware deve seguire Authenticate nell'elenco di Use.
+
+  sm.Use(sm.Authenticate(sessioni, utenti, token), sm.CSRF(webman.CSRFOptions{}))
+
+Il metodo genera un panic con l'errore ErrInvalidOrigin se un'origine fidata non è valida.
+*/
+func (sm *ServerManager) CSRF(opt CSRFOptions) Middleware {
+	if opt.CookieName == "" {
+		opt.CookieName = DefaultCSRFCookie
+	}
+	if opt.FieldName == "" {
+		opt.FieldName = DefaultCSRFField
+	}
+	if opt.HeaderName == "" {
+		opt.HeaderName = DefaultCSRFHeader
+	}
+	trusted := &corsPolicy{}
+	for _, o := range opt.TrustedOrigins {
+		if _, err := path.Match(o, ""); (err != nil) || (o == "*") || strings.HasSuffix(o, "/") {
+			panic(fmt.Errorf("%w: %s", ErrInvalidOrigin, o))
+		}
+		trusted.origins = append(trusted.origins, strings.ToLower(o))
+	}
+	maxBytes := maxBodySize(opt.MaxBytes)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			st := &csrfState{field: opt.FieldName}
+			if c, err := r.Cookie(opt.CookieName); err == nil {
+				if b, err := base64.RawURLEncoding.DecodeString(c.Value); (err == nil) && (len(b) == csrfSecretSize) {
+					st.secret = b
+				}
+			}
+			fresh := st.secret == nil
+			if fresh {
+				st.secret = make([]byte, csrfSecretSize)
+				rand.Read(st.secret)
+				http.SetCookie(w, &http.Cookie{
+					Name:     opt.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(st.secret),
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   opt.Secure || (r.TLS != nil),
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, st))
+
+			if safeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if p := RequestPrincipal(r); (p != nil) && (p.Scheme == "bearer") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err := checkOrigin(r, trusted)
+			if (err == nil) && fresh {
+				err = fmt.Errorf("%w: cookie %s assente", ErrCSRF, opt.CookieName)
+			}
+			if err == nil {
+				token := r.Header.Get(opt.HeaderName)
+				if token == "" {
+					if token, err = formToken(st, maxBytes, w, r); err != nil {
+						replyDecodeError(err, w, r)
+						return
+					}
+				}
+				if !st.validToken(token) {
+					err = fmt.Errorf("%w: token mancante o non valido", ErrCSRF)
+				}
+			}
+			if err != nil {
+				sm.log.Printf("CSRF: RICHIESTA RIFIUTATA [%s]: %s %s: %v\n", RequestID(r), r.Method, r.URL.Path, err)
+				sm.ReplyStatus(http.StatusForbidden, "Richiesta rifiutata: token CSRF mancante o non valido.", w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//DefaultMaxBodySize è la dimensione massima di default in byte del corpo di una richiesta letto da DecodeJSON e DecodeForm.
+const DefaultMaxBodySize int64 = 1 << 20
+
+//ErrUnsupportedMediaType è l'errore restituito quando il formato del corpo della richiesta non è quello previsto.
+var ErrUnsupportedMediaType error = errors.New("unsupported media type")
+
+//ErrBodyTooLarge è l'errore restituito quando il corpo della richiesta supera la dimensione massima.
+var ErrBodyTooLarge error = errors.New("request body too large")
+
+//ErrInvalidBody è l'errore restituito quando il corpo della richiesta non può essere decodificato.
+var ErrInvalidBody error = errors.New("invalid request body")
+
+//ErrInvalidTarget è l'errore del panic generato quando il valore in cui decodificare un modulo non è un puntatore a struct.
+var ErrInvalidTarget error = errors.New("invalid decode target")
+
+//ErrInvalidRule è l'errore del panic generato da una regola non valida nel tag validate.
+var ErrInvalidRule error = errors.New("invalid validation rule")
+
+// ===== Tipo FieldError =====
+
+//FieldError descrive un errore di convalida relativo a un campo dei dati ricevuti.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+//Error restituisce il messaggio dell'errore preceduto dal nome del campo.
+func (fe FieldError) Error() string {
+	return fe.Field + ": " + fe.Message
+}
+
+// ===== Tipo ValidationErrors =====
+
+//ValidationErrors raccoglie gli errori di convalida dei dati ricevuti.
+type ValidationErrors []FieldError
+
+//Error restituisce i messaggi degli errori separati da punto e virgola.
+func (ve ValidationErrors) Error() string {
+	msg := make([]string, 0, len(ve))
+	for _, e := range ve {
+		msg = append(msg, e.Error())
+	}
+	return strings.Join(msg, "; ")
+}
+
+/*
+DecodeJSON decodifica nel valore puntato da v il corpo in formato JSON della richiesta e lo convalida.
+
+Il corpo deve avere il tipo di contenuto "application/json" o un tipo con suffisso "+json",
+non può superare maxBytes byte (DefaultMaxBodySize se maxBytes è minore o uguale a zero),
+non può contenere campi che non esistono in v né altri dati dopo il valore JSON.
+Dopo la decodifica, se v è una struct, i campi sono convalidati con le regole del tag validate
+descritte in DecodeForm; i nomi dei campi negli errori sono quelli del tag json.
+
+Restituisce nil se la decodifica e la convalida sono riuscite, altrimenti un errore che soddisfa errors.Is con
+ErrUnsupportedMediaType, ErrBodyTooLarge o ErrInvalidBody, oppure un errore ValidationErrors.
+Se w non è nil, in caso di errore risponde con la funzione WriteProblem, il codice 415, 413 o 400
+e la descrizione dell'errore; per gli errori di convalida il problema contiene anche il membro "errors"
+con l'elenco dei campi non validi. Il chiamante deve solo terminare la gestione della richiesta.
+
+  var nt NotaAPI
+  if err := webman.DecodeJSON(r, &nt, 0, w); err != nil {
+    return
+  }
+*/
+func DecodeJSON(r *http.Request, v any, maxBytes int64, w http.ResponseWriter) error {
+	err := decodeJSON(r, v, maxBytes, w)
+	if (err != nil) && (w != nil) {
+		replyDecodeError(err, w, r)
+	}
+	return err
+}
+
+// decodeJSON esegue la decodifica per DecodeJSON.
+func decodeJSON(r *http.Request, v any, maxBytes int64, w http.ResponseWriter) error {
+	mt, err := requestMediaType(r)
+	if err != nil {
+		return err
+	}
+	if (mt != "application/json") && !strings.HasSuffix(mt, "+json") {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mt)
+	}
+	if r.Body == nil {
+		return fmt.Errorf("%w: empty body", ErrInvalidBody)
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize(maxBytes)))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(v); err != nil {
+		return bodyError(err)
+	}
+	// il corpo deve contenere un solo valore JSON
+	if err = dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+		return bodyError(err)
+	}
+
+	return validate(reflect.ValueOf(v), "json")
+}
+
+/*
+DecodeForm decodifica nella struct puntata da v i valori del modulo ricevuto con la richiesta e li convalida.
+
+Per le richieste POST, PUT e PATCH i valori sono letti dal corpo, che deve avere il tipo di contenuto
+"application/x-www-form-urlencoded" o "multipart/form-data" e non può superare maxBytes byte
+(DefaultMaxBodySize se maxBytes è minore o uguale a zero); per gli altri metodi sono letti dalla query dell'URL.
+
+Ogni campo esportato di v riceve il valore con il nome indicato dal tag `form:"nome"`, oppure con il nome
+del campo se il tag è assente; i campi con il tag `form:"-"` sono ignorati. Sono gestiti i campi di tipo
+string, bool, numeri interi e decimali, i puntatori a questi tipi, impostati solo se il valore è presente,
+e gli slice di questi tipi, che ricevono tutti i valori con lo stesso nome. Per i campi bool il valore "on"
+inviato dalle checkbox HTML equivale a true. I valori con nomi che non corrispondono a nessun campo
+sono rifiutati, tranne il token del middleware CSRF.
+
+Dopo la decodifica i campi sono convalidati con le regole del tag validate separate da virgole,
+fermandosi per ogni campo alla prima regola non soddisfatta:
+
+  required      il valore deve essere presente: stringa non vuota, numero diverso da zero, puntatore non nil
+  min=n, max=n  limiti del valore per i numeri, del numero di caratteri per le stringhe
+                e del numero di elementi per slice e mappe
+  oneof=a b c   il valore deve essere uno di quelli elencati, separati da spazi
+
+Ad esempio:
+
+  type Modulo struct {
+    ID    int64   `form:"id" validate:"required,min=1"`
+    Testo string  `form:"nota" validate:"max=200"`
+    Corpo *string `form:"corpo"`
+  }
+
+Restituisce gli stessi errori di DecodeJSON e, se w non è nil, risponde nello stesso modo in caso di errore.
+La funzione genera un panic con l'errore ErrInvalidTarget se v non è un puntatore a struct
+e con l'errore ErrInvalidRule se una regola del tag validate non è valida.
+*/
+func DecodeForm(r *http.Request, v any, maxBytes int64, w http.ResponseWriter) error {
+	err := decodeForm(r, v, maxBytes, w)
+	if (err != nil) && (w != nil) {
+		replyDecodeError(err, w, r)
+	}
+	return err
+}
+
+// decodeForm esegue la decodifica per DecodeForm.
+func decodeForm(r *http.Request, v any, maxBytes int64, w http.ResponseWriter) error {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Pointer) || rv.IsNil() || (rv.Elem().Kind() != reflect.Struct) {
+		panic(fmt.Errorf("%w: %T non è un puntatore a struct", ErrInvalidTarget, v))
+	}
+
+	var values map[string][]string
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		mt, err := requestMediaType(r)
+		if err != nil {
+			return err
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodySize(maxBytes))
+		}
+		switch mt {
+		case "application/x-www-form-urlencoded":
+			err = r.ParseForm()
+		case "multipart/form-data":
+			err = r.ParseMultipartForm(maxBodySize(maxBytes))
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mt)
+		}
+		if err != nil {
+			return bodyError(err)
+		}
+		values = r.PostForm
+	default:
+		values = r.URL.Query()
+	}
+
+	var ignore string
+	if st := requestCSRF(r); st != nil {
+		ignore = st.field
+	}
+	if err := setFormFields(rv.Elem(), values, ignore); err != nil {
+		return err
+	}
+	return validate(rv, "form")
+}
+
+// requestMediaType restituisce il tipo di contenuto della richiesta senza parametri.
+func requestMediaType(r *http.Request) (string, error) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return "", fmt.Errorf("%w: missing Content-Type", ErrUnsupportedMediaType)
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
+	}
+	return mt, nil
+}
+
+// maxBodySize restituisce la dimensione massima del corpo, DefaultMaxBodySize se maxBytes non è positivo.
+func maxBodySize(maxBytes int64) int64 {
+	if maxBytes <= 0 {
+		return DefaultMaxBodySize
+	}
+	return maxBytes
+}
+
+// bodyError converte un errore di lettura o decodifica del corpo in ErrBodyTooLarge o ErrInvalidBody.
+func bodyError(err error) error {
+	var mbe *http.MaxBytesError
+	switch {
+	case errors.As(err, &mbe):
+		return fmt.Errorf("%w: limit %d bytes", ErrBodyTooLarge, mbe.Limit)
+	case err == io.EOF:
+		return fmt.Errorf("%w: empty body", ErrInvalidBody)
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidBody, err)
+}
+
+// replyDecodeError risponde con il codice di stato corrispondente all'errore di decodifica.
+func replyDecodeError(err error, w http.ResponseWriter, r *http.Request) {
+	code, message, sentinel := http.StatusBadRequest, "dati non validi", error(nil)
+	switch {
+	case errors.Is(err, ErrUnsupportedMediaType):
+		code, message, sentinel = http.StatusUnsupportedMediaType, "formato della richiesta non supportato", ErrUnsupportedMediaType
+	case errors.Is(err, ErrBodyTooLarge):
+		code, message, sentinel = http.StatusRequestEntityTooLarge, "corpo della richiesta troppo grande", ErrBodyTooLarge
+	case errors.Is(err, ErrInvalidBody):
+		message, sentinel = "corpo della richiesta non valido", ErrInvalidBody
+	}
+	// il dettaglio dell'errore senza il testo dell'errore generico
+	detail := err.Error()
+	if sentinel != nil {
+		detail = strings.TrimPrefix(strings.TrimPrefix(detail, sentinel.Error()), ": ")
+	}
+	if detail != "" {
+		message += ": " + detail
+	}
+	p := NewProblem(code, message)
+	var ve ValidationErrors
+	if errors.As(err, &ve) {
+		p.Extensions = map[string]any{"errors": ve}
+	}
+	WriteProblem(p, w, r)
+}
+
+// ===== Decodifica dei moduli =====
+
+// formFieldName restituisce il nome del campo nel modulo, vuoto se il campo è ignorato.
+func formFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	if tag, ok := f.Tag.Lookup("form"); ok {
+		if tag == "-" {
+			return ""
+		}
+		if tag = strings.Split(tag, ",")[0]; tag != "" {
+			return tag
+		}
+	}
+	return f.Name
+}
+
+// setFormFields imposta i campi della struct con i valori del modulo
+// e restituisce ValidationErrors per i valori non convertibili o ErrInvalidBody per i nomi sconosciuti,
+// escluso il nome ignore del campo con il token CSRF.
+func setFormFields(sv reflect.Value, values map[string][]string, ignore string) error {
+	known := make(map[string]bool, len(values))
+	if ignore != "" {
+		known[ignore] = true
+	}
+	var errs ValidationErrors
+	for i := 0; i < sv.NumField(); i++ {
+		name := formFieldName(sv.Type().Field(i))
+		if name == "" {
+			continue
+		}
+		known[name] = true
+		vals, ok := values[name]
+		if !ok || (len(vals) == 0) {
+			continue
+		}
+		if err := setFormValue(sv.Field(i), vals); err != nil {
+			errs = append(errs, FieldError{Field: name, Rule: "type", Message: "valore non valido"})
+		}
+	}
+
+	for name := range values {
+		if !known[name] {
+			return fmt.Errorf("%w: unknown field %q", ErrInvalidBody, name)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setFormValue imposta il campo con i valori del modulo: tutti per gli slice, il primo negli altri casi.
+func setFormValue(fv reflect.Value, vals []string) error {
+	switch fv.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(fv.Type().Elem())
+		if err := setFormValue(elem.Elem(), vals); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	case reflect.Slice:
+		slc := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, s := range vals {
+			if err := setScalar(slc.Index(i), s); err != nil {
+				return err
+			}
+		}
+		fv.Set(slc)
+		return nil
+	}
+	return setScalar(fv, vals[0])
+}
+
+// setScalar imposta un campo di tipo semplice convertendo il testo s.
+func setScalar(fv reflect.Value, s string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if s == "on" {
+			fv.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// ===== Convalida =====
+
+// validate convalida i campi di una struct, o di un puntatore a struct, con le regole del tag validate.
+// I nomi dei campi negli errori sono presi dal tag nameTag. Gli altri valori sono sempre validi.
+func validate(v reflect.Value, nameTag string) error {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		rules, ok := f.Tag.Lookup("validate")
+		if !ok || !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get(nameTag), ",")[0]; (tag != "") && (tag != "-") {
+			name = tag
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if msg := checkRule(v.Field(i), rule); msg != "" {
+				ruleName, _, _ := strings.Cut(rule, "=")
+				errs = append(errs, FieldError{Field: name, Rule: ruleName, Message: msg})
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkRule restituisce il messaggio di errore se il valore non soddisfa la regola, altrimenti una stringa vuota.
+// Le regole min, max e oneof non sono verificate sui puntatori nil.
+func checkRule(fv reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if fv.IsZero() || ((fv.Kind() == reflect.String) && (strings.TrimSpace(fv.String()) == "")) {
+			return "campo obbligatorio"
+		}
+		return ""
+	}
+
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	}
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Errorf("%w: %q", ErrInvalidRule, rule))
+		}
+		var size float64
+		var unit string
+		switch fv.Kind() {
+		case reflect.String:
+			size, unit = float64(utf8.RuneCountInString(fv.String())), " caratteri"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			size, unit = float64(fv.Len()), " elementi"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = float64(fv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = float64(fv.Uint())
+		case reflect.Float32, reflect.Float64:
+			size = fv.Float()
+		default:
+			panic(fmt.Errorf("%w: %q non applicabile al tipo %s", ErrInvalidRule, rule, fv.Type()))
+		}
+		if (name == "min") && (size < limit) {
+			return "minimo " + arg + unit
+		}
+		if (name == "max") && (size > limit) {
+			return "massimo " + arg + unit
+		}
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, allowed := range strings.Fields(arg) {
+			if s == allowed {
+				return ""
+			}
+		}
+		return "valore non ammesso, valori validi: " + strings.Join(strings.Fields(arg), ", ")
+	case "":
+	default:
+		panic(fmt.Errorf("%w: %q sconosciuta", ErrInvalidRule, rule))
+	}
+	return ""
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+/*
+Stream rappresenta un elenco di elementi prodotti uno alla volta, ad esempio letti da un database.
+
+La funzione chiama yield per ogni elemento e deve fermarsi quando yield restituisce false.
+Gli encoder scrivono ogni elemento nella risposta appena ricevuto, senza raccogliere l'elenco in memoria.
+Stream ha la forma di iter.Seq[any], quindi può essere usato anche con range nei template.
+*/
+type Stream func(yield func(item any) bool)
+
+/*
+Encoder scrive un valore nella risposta in un formato specificato.
+
+Se il valore è uno slice, un array o uno Stream, l'encoder lo scrive come elenco di elementi.
+*/
+type Encoder interface {
+	// ContentType restituisce il tipo di contenuto scritto dall'encoder, usato nella negoziazione e nella risposta.
+	ContentType() string
+	// Encode scrive il valore v su w.
+	Encode(w io.Writer, v any) error
+}
+
+//DefaultEncoders restituisce gli encoder usati da Serve se non ne sono specificati altri,
+//nell'ordine di preferenza del server: JSON, XML, CSV, NDJSON e testo.
+func DefaultEncoders() []Encoder {
+	return []Encoder{JSONEncoder{}, XMLEncoder{}, CSVEncoder{}, NDJSONEncoder{}, TextEncoder{}}
+}
+
+/*
+Serve risponde alla richiesta specificata con il valore nel parametro reply e il codice di stato specificato,
+scritto con l'encoder il cui tipo di contenuto è scelto dalla funzione Negotiate fra quelli degli encoder specificati
+o, se non ne sono specificati, di DefaultEncoders.
+
+Se la richiesta non accetta nessun tipo di contenuto, la risposta è 406 NotAcceptable come in CheckAccept.
+Nell'intestazione della risposta sono impostati "Content-Type" con il tipo dell'encoder e "Vary" su "Accept".
+
+Se reply non è uno Stream, il valore è prima scritto in memoria: in caso di errore la risposta è 500,
+inviata con la funzione WriteProblem, e Serve restituisce l'errore.
+Se reply è uno Stream, gli elementi sono scritti nella risposta man mano che sono prodotti:
+un errore a metà elenco non può più cambiare il codice di stato, quindi Serve lo restituisce
+e il chiamante può interrompere la risposta con panic(http.ErrAbortHandler).
+*/
+func Serve(r *http.Request, reply any, code int, w http.ResponseWriter, encoders ...Encoder) error {
+	if len(encoders) == 0 {
+		encoders = DefaultEncoders()
+	}
+	offers := make([]string, len(encoders))
+	for i, enc := range encoders {
+		offers[i] = enc.ContentType()
+	}
+	w.Header().Add("Vary", "Accept")
+	if !CheckAccept(r, offers, true, w) {
+		return nil
+	}
+	chosen := Negotiate(r, offers...)
+	var enc Encoder
+	for i := range offers {
+		if offers[i] == chosen {
+			enc = encoders[i]
+			break
+		}
+	}
+
+	if _, ok := reply.(Stream); ok {
+		w.Header().Set("Content-Type", chosen)
+		w.WriteHeader(code)
+		return enc.Encode(w, reply)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, reply); err != nil {
+		WriteProblem(NewProblem(http.StatusInternalServerError, err.Error()), w, r)
+		return err
+	}
+	w.Header().Set("Content-Type", chosen)
+	w.WriteHeader(code)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// eachItem chiama fn per ogni elemento di v se v è uno Stream, uno slice o un array
+// e restituisce false senza chiamare fn per gli altri valori. I []byte non sono considerati elenchi.
+func eachItem(v any, fn func(item any) error) (list bool, err error) {
+	if s, ok := v.(Stream); ok {
+		s(func(item any) bool {
+			err = fn(item)
+			return (err == nil)
+		})
+		return true, err
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return false, nil
+		}
+		for i := 0; i < rv.Len() && err == nil; i++ {
+			err = fn(rv.Index(i).Interface())
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// ===== Tipo JSONEncoder =====
+
+//JSONEncoder scrive i valori in formato JSON. Gli elenchi sono scritti come array un elemento alla volta.
+type JSONEncoder struct{}
+
+//ContentType restituisce "application/json; charset=utf-8".
+func (JSONEncoder) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+//Encode scrive v in formato JSON su w.
+func (JSONEncoder) Encode(w io.Writer, v any) error {
+	if _, ok := v.(Stream); !ok {
+		return json.NewEncoder(w).Encode(v)
+	}
+	sep := "["
+	_, err := eachItem(v, func(item any) error {
+		dati, err := json.Marshal(item)
+		if err == nil {
+			if _, err = io.WriteString(w, sep); err == nil {
+				_, err = w.Write(dati)
+			}
+		}
+		sep = ","
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if sep == "[" {
+		// elenco vuoto
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "]\n")
+	}
+	return err
+}
+
+// ===== Tipo NDJSONEncoder =====
+
+//NDJSONEncoder scrive i valori in formato JSON delimitato da ritorni a capo (NDJSON):
+//ogni elemento di un elenco su una riga, un valore singolo su una sola riga.
+type NDJSONEncoder struct{}
+
+//ContentType restituisce "application/x-ndjson".
+func (NDJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+//Encode scrive v in formato NDJSON su w.
+func (NDJSONEncoder) Encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	list, err := eachItem(v, enc.Encode)
+	if !list {
+		err = enc.Encode(v)
+	}
+	return err
+}
+
+// ===== Tipo XMLEncoder =====
+
+/*
+XMLEncoder scrive i valori in formato XML con la dichiarazione iniziale.
+
+Gli elenchi sono racchiusi nell'elemento con il nome Root, "elenco" se vuoto.
+Il nome di ogni elemento è quello del tipo o del campo XMLName, come previsto da encoding/xml.
+*/
+type XMLEncoder struct {
+	Root string
+}
+
+//ContentType restituisce "application/xml; charset=utf-8".
+func (XMLEncoder) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+//Encode scrive v in formato XML su w.
+func (xe XMLEncoder) Encode(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	root := xml.StartElement{Name: xml.Name{Local: xe.Root}}
+	if root.Name.Local == "" {
+		root.Name.Local = "elenco"
+	}
+
+	started := false
+	list, err := eachItem(v, func(item any) error {
+		if !started {
+			started = true
+			if err := enc.EncodeToken(root); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(item)
+	})
+	switch {
+	case err != nil:
+		return err
+	case !list:
+		err = enc.Encode(v)
+	case !started:
+		// elenco vuoto
+		err = enc.EncodeToken(root)
+		fallthrough
+	default:
+		if err == nil {
+			err = enc.EncodeToken(root.End())
+		}
+	}
+	if err == nil {
+		err = enc.Flush()
+	}
+	return err
+}
+
+// ===== Tipo CSVEncoder =====
+
+/*
+CSVEncoder scrive i valori in formato CSV.
+
+Per gli elementi di tipo struct, o puntatore a struct, la prima riga contiene i nomi dei campi esportati,
+sostituiti dal valore del tag `csv:"nome"` se presente; i campi con il tag `csv:"-"` sono ignorati.
+I valori sono scritti con fmt.Sprint. Gli elementi di tipo []string sono scritti come righe senza intestazione,
+gli altri valori in una sola colonna.
+*/
+type CSVEncoder struct{}
+
+//ContentType restituisce "text/csv; charset=utf-8".
+func (CSVEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+//Encode scrive v in formato CSV su w.
+func (CSVEncoder) Encode(w io.Writer, v any) error {
+	cw := csv.NewWriter(w)
+	header := false
+	write := func(item any) error {
+		if row, ok := item.([]string); ok {
+			return cw.Write(row)
+		}
+		rv := reflect.Indirect(reflect.ValueOf(item))
+		if rv.Kind() != reflect.Struct {
+			return cw.Write([]string{fmt.Sprint(item)})
+		}
+		fields := csvFields(rv.Type())
+		if !header {
+			header = true
+			names := make([]string, len(fields))
+			for i, f := range fields {
+				names[i] = f.name
+			}
+			if err := cw.Write(names); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			row[i] = csvValue(rv.Field(f.index))
+		}
+		return cw.Write(row)
+	}
+
+	list, err := eachItem(v, write)
+	if !list && v != nil {
+		err = write(v)
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	return err
+}
+
+type csvField struct {
+	name  string
+	index int
+}
+
+// csvFields restituisce nome e indice dei campi esportati del tipo struct.
+func csvFields(t reflect.Type) (fields []csvField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("csv"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag = strings.Split(tag, ",")[0]; tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, csvField{name: name, index: i})
+	}
+	return
+}
+
+// csvValue restituisce il testo del valore di un campo, vuoto per i puntatori nil.
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// ===== Tipo TextEncoder =====
+
+//TextEncoder scrive i valori come testo con fmt.Fprint, quindi con il metodo String se presente.
+//Gli elementi di un elenco sono scritti uno per riga.
+type TextEncoder struct{}
+
+//ContentType restituisce "text/plain; charset=utf-8".
+func (TextEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+//Encode scrive v come testo su w.
+func (TextEncoder) Encode(w io.Writer, v any) error {
+	list, err := eachItem(v, func(item any) error {
+		_, err := fmt.Fprintln(w, item)
+		return err
+	})
+	if !list {
+		switch b := v.(type) {
+		case []byte:
+			_, err = w.Write(b)
+		default:
+			_, err = fmt.Fprint(w, v)
+		}
+	}
+	return err
+}
+
+// ===== Tipo HTMLEncoder =====
+
+//HTMLEncoder scrive i valori eseguendo il template HTML con il nome Name dell'insieme Template.
+//Gli Stream sono passati al template così come sono e possono essere scorsi con range.
+type HTMLEncoder struct {
+	Template *template.Template
+	Name     string
+}
+
+//ContentType restituisce "text/html; charset=utf-8".
+func (HTMLEncoder) ContentType() string {
+	return "text/html; charset=utf-8"
+}
+
+//Encode esegue il template con il valore v e scrive il risultato su w.
+func (he HTMLEncoder) Encode(w io.Writer, v any) error {
+	return he.Template.ExecuteTemplate(w, he.Name, v)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultHealthTimeout è il tempo massimo di default di un controllo di salute.
+const DefaultHealthTimeout time.Duration = 2 * time.Second
+
+//DefaultHealthCache è la durata di default per cui è riutilizzato il risultato di un controllo di salute.
+const DefaultHealthCache time.Duration = time.Second
+
+//ErrShuttingDown è l'errore del controllo "shutdown" della prontezza durante la chiusura del server.
+var ErrShuttingDown error = errors.New("server shutting down")
+
+//ErrInvalidHealthCheck è l'errore usato nel panic quando un controllo di salute non ha un nome valido o non ha una funzione.
+var ErrInvalidHealthCheck error = errors.New("invalid health check")
+
+//HealthCheck è la funzione di un controllo di salute: restituisce nil se il componente funziona.
+//Deve terminare quando il contesto scade.
+type HealthCheck func(ctx context.Context) error
+
+/*
+HealthOptions contiene le impostazioni di Health.
+
+  Timeout     tempo massimo di ogni controllo, DefaultHealthTimeout se minore o uguale a zero
+  Cache       durata per cui è riutilizzato il risultato di un controllo, DefaultHealthCache se zero;
+              con un valore negativo i controlli sono eseguiti a ogni richiesta
+  DrainDelay  attesa del metodo Shutdown dopo il passaggio allo stato non pronto, per dare tempo
+              al bilanciatore o al supervisore di smettere di inviare richieste
+*/
+type HealthOptions struct {
+	Timeout    time.Duration
+	Cache      time.Duration
+	DrainDelay time.Duration
+}
+
+/*
+HealthResult contiene il risultato di un controllo di salute nella risposta JSON.
+
+  Status    "ok" oppure "fail"
+  Error     testo dell'errore del controllo non riuscito
+  Duration  durata del controllo in millisecondi
+  Checked   istante del controllo, precedente alla richiesta se il risultato è riutilizzato
+*/
+type HealthResult struct {
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"duration_ms"`
+	Checked  time.Time `json:"checked"`
+}
+
+//HealthReport è la risposta JSON dei gestori di Health: lo stato complessivo e il risultato di ogni controllo.
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks,omitempty"`
+}
+
+// healthEntry contiene un controllo registrato e l'ultimo risultato.
+// Il mutex fa attendere le richieste contemporanee invece di ripetere il controllo.
+type healthEntry struct {
+	name     string
+	check    HealthCheck
+	liveness bool
+	mu       sync.Mutex
+	result   HealthResult
+	expires  time.Time
+}
+
+// ===== Tipo Health =====
+
+/*
+Health raccoglie i controlli di salute dei componenti di un'applicazione e li espone
+ai supervisori dei processi e ai bilanciatori con due gestori che rispondono in JSON:
+
+  ServeLiveness   vivacità (/healthz): il processo risponde e i controlli di vivacità riescono;
+                  se fallisce il processo va riavviato
+  ServeReadiness  prontezza (/readyz): anche i controlli di prontezza riescono, ad esempio il database
+                  è raggiungibile, e il server non è in chiusura; se fallisce non vanno inviate richieste
+
+La risposta ha il codice 200 se tutti i controlli riescono, altrimenti 503 ServiceUnavailable.
+I controlli sono eseguiti in parallelo, ognuno con il tempo massimo delle opzioni,
+e il loro risultato è riutilizzato per la durata Cache, così le verifiche frequenti non sovraccaricano i componenti.
+Le risposte contengono il testo degli errori dei controlli: se non deve essere pubblico,
+associa i gestori a percorsi riservati oppure restituisci errori generici.
+
+  salute := webman.NewHealth(webman.HealthOptions{DrainDelay: 5 * time.Second})
+  salute.AddReadinessCheck("database", db.PingContext)
+  sm.EnlistMethodFuncOK(http.MethodGet, "/healthz", salute.ServeLiveness)
+  sm.EnlistMethodFuncOK(http.MethodGet, "/readyz", salute.ServeReadiness)
+  lc.BeforeShutdown("prontezza", salute.Shutdown)
+*/
+type Health struct {
+	timeout  time.Duration
+	cache    time.Duration
+	drain    time.Duration
+	mu       sync.Mutex
+	checks   []*healthEntry
+	shutdown atomic.Bool
+}
+
+//NewHealth restituisce un Health senza controlli con le impostazioni specificate.
+func NewHealth(opt HealthOptions) *Health {
+	h := &Health{timeout: opt.Timeout, cache: opt.Cache, drain: opt.DrainDelay}
+	if h.timeout <= 0 {
+		h.timeout = DefaultHealthTimeout
+	}
+	if h.cache == 0 {
+		h.cache = DefaultHealthCache
+	}
+	return h
+}
+
+// addCheck registra un controllo, generando un panic se il nome è vuoto o già usato.
+func (h *Health) addCheck(name string, check HealthCheck, liveness bool) {
+	if (name == "") || (name == "shutdown") || (check == nil) {
+		panic(fmt.Errorf("%w: %q", ErrInvalidHealthCheck, name))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.checks {
+		if e.name == name {
+			panic(fmt.Errorf("%w: %q già usato", ErrInvalidHealthCheck, name))
+		}
+	}
+	h.checks = append(h.checks, &healthEntry{name: name, check: check, liveness: liveness})
+}
+
+//AddLivenessCheck registra un controllo di vivacità, eseguito sia da ServeLiveness che da ServeReadiness.
+//Deve verificare solo lo stato del processo: se dipende da un servizio esterno, il suo guasto farebbe riavviare il processo.
+//Il metodo genera un panic con l'errore ErrInvalidHealthCheck se il nome è vuoto, è "shutdown" o è già usato.
+func (h *Health) AddLivenessCheck(name string, check HealthCheck) {
+	h.addCheck(name, check, true)
+}
+
+//AddReadinessCheck registra un controllo di prontezza, eseguito solo da ServeReadiness,
+//ad esempio la verifica della connessione al database.
+func (h *Health) AddReadinessCheck(name string, check HealthCheck) {
+	h.addCheck(name, check, false)
+}
+
+// run restituisce il risultato del controllo, riutilizzando quello precedente se non è scaduto.
+func (h *Health) run(ctx context.Context, e *healthEntry) HealthResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if now.Before(e.expires) {
+		return e.result
+	}
+
+	// il risultato è condiviso con le altre richieste: non dipende dall'annullamento di questa
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// il controllo non rispetta il contesto: il risultato arriverà senza essere letto
+		err = ctx.Err()
+	}
+
+	e.result = HealthResult{Status: "ok", Duration: float64(time.Since(now).Microseconds()) / 1000, Checked: now}
+	if err != nil {
+		e.result.Status, e.result.Error = "fail", err.Error()
+	}
+	if h.cache > 0 {
+		e.expires = now.Add(h.cache)
+	}
+	return e.result
+}
+
+// report esegue i controlli in parallelo e restituisce il rapporto e il codice di stato della risposta.
+func (h *Health) report(ctx context.Context, readiness bool) (HealthReport, int) {
+	h.mu.Lock()
+	checks := append([]*healthEntry(nil), h.checks...)
+	h.mu.Unlock()
+
+	rep := HealthReport{Status: "ok", Checks: make(map[string]HealthResult, len(checks)+1)}
+	if readiness && h.shutdown.Load() {
+		// in chiusura: i controlli non servono
+		rep.Checks["shutdown"] = HealthResult{Status: "fail", Error: ErrShuttingDown.Error(), Checked: time.Now()}
+		rep.Status = "fail"
+		return rep, http.StatusServiceUnavailable
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, e := range checks {
+		if !readiness && !e.liveness {
+			continue
+		}
+		wg.Add(1)
+		go func(e *healthEntry) {
+			defer wg.Done()
+			res := h.run(ctx, e)
+			mu.Lock()
+			rep.Checks[e.name] = res
+			mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+
+	for _, res := range rep.Checks {
+		if res.Status != "ok" {
+			rep.Status = "fail"
+			return rep, http.StatusServiceUnavailable
+		}
+	}
+	return rep, http.StatusOK
+}
+
+// serve risponde con il rapporto dei controlli in JSON.
+func (h *Health) serve(readiness bool, w http.ResponseWriter, r *http.Request) {
+	rep, code := h.report(r.Context(), readiness)
+	dati, _ := json.Marshal(rep)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(dati)
+}
+
+//ServeLiveness risponde con il risultato dei controlli di vivacità.
+func (h *Health) ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	h.serve(false, w, r)
+}
+
+//ServeReadiness risponde con il risultato di tutti i controlli, oppure con il codice 503
+//e il controllo "shutdown" non riuscito dopo la chiamata del metodo Shutdown.
+func (h *Health) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	h.serve(true, w, r)
+}
+
+/*
+Shutdown fa fallire la prontezza e attende DrainDelay o la scadenza del contesto,
+mentre il server continua a servire le richieste. Va registrato con Lifecycle.BeforeShutdown,
+così il bilanciatore smette di inviare richieste prima che il server chiuda le connessioni.
+*/
+func (h *Health) Shutdown(ctx context.Context) error {
+	h.shutdown.Store(true)
+	if h.drain <= 0 {
+		return nil
+	}
+	t := time.NewTimer(h.drain)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//DefaultShutdownTimeout è il tempo massimo di default per completare le richieste in corso alla chiusura.
+const DefaultShutdownTimeout time.Duration = 10 * time.Second
+
+//ErrLifecycleStarted è l'errore restituito da Run quando il ciclo di vita è già stato avviato.
+var ErrLifecycleStarted error = errors.New("lifecycle already started")
+
+// ===== Tipo shutdownHook =====
+
+type shutdownHook struct {
+	name string
+	hook func(ctx context.Context) error
+}
+
+// ===== Tipo Lifecycle =====
+
+/*
+Lifecycle gestisce l'avvio e la chiusura ordinata di un http.Server.
+
+Il metodo Run avvia il server e attende un segnale SIGINT o SIGTERM oppure una chiamata
+al metodo Stop. Alla chiusura il server smette di accettare connessioni e attende con
+http.Server.Shutdown che le richieste in corso siano completate entro il tempo massimo
+specificato, poi chiude le connessioni rimaste ed esegue nell'ordine le funzioni di chiusura
+registrate con OnShutdown, ad esempio per chiudere il database. Le funzioni registrate
+con BeforeShutdown sono eseguite prima della chiusura del server, mentre le richieste sono ancora servite:
+
+  lc := webman.NewLifecycle(server, 0, nil)
+  lc.BeforeShutdown("prontezza", salute.Shutdown)
+  lc.OnShutdown("database", func(ctx context.Context) error { return db.Close() })
+  if err := lc.Run(); err != nil {
+    log.Fatalln(err)
+  }
+*/
+type Lifecycle struct {
+	server  *http.Server
+	timeout time.Duration
+	log     *log.Logger
+	hooks   []shutdownHook
+	drains  []shutdownHook
+	mu      sync.Mutex
+	started bool
+	stop    chan struct{}
+	once    sync.Once
+}
+
+/*
+NewLifecycle restituisce un oggetto Lifecycle per il server specificato.
+
+Il parametro timeout indica il tempo massimo per completare le richieste in corso alla chiusura;
+se è minore o uguale a zero è usato DefaultShutdownTimeout.
+
+Il log è scritto sull'oggetto log.Logger specificato. Se il parametro è nil, Lifecycle scrive su os.Stderr.
+*/
+func NewLifecycle(server *http.Server, timeout time.Duration, logger *log.Logger) *Lifecycle {
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	if logger == nil {
+		logger = log.New(os.Stderr, "", (log.LstdFlags | log.LUTC))
+	}
+	return &Lifecycle{server: server, timeout: timeout, log: logger, stop: make(chan struct{})}
+}
+
+//OnShutdown registra una funzione eseguita alla chiusura, dopo il server e dopo le funzioni registrate in precedenza.
+//Il contesto passato alla funzione scade insieme al tempo massimo di chiusura.
+func (lc *Lifecycle) OnShutdown(name string, hook func(ctx context.Context) error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.hooks = append(lc.hooks, shutdownHook{name: name, hook: hook})
+}
+
+/*
+BeforeShutdown registra una funzione eseguita all'avvio della chiusura, prima che il server smetta
+di accettare connessioni, dopo le funzioni registrate in precedenza con lo stesso metodo.
+Ad esempio Health.Shutdown fa fallire la prontezza e attende che il bilanciatore smetta di inviare richieste.
+
+Il contesto passato alla funzione scade insieme al tempo massimo di chiusura, che comprende anche
+l'attesa delle richieste in corso: l'attesa di queste funzioni deve essere più breve.
+*/
+func (lc *Lifecycle) BeforeShutdown(name string, hook func(ctx context.Context) error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.drains = append(lc.drains, shutdownHook{name: name, hook: hook})
+}
+
+//Stop avvia la chiusura del server senza attenderne il completamento.
+//Può essere chiamato più volte e anche da un gestore di richiesta.
+func (lc *Lifecycle) Stop() {
+	lc.once.Do(func() { close(lc.stop) })
+}
+
+/*
+Run avvia il server con http.Server.ListenAndServe e resta in attesa fino alla chiusura.
+
+Restituisce nil se la chiusura è completata senza errori, altrimenti l'errore dell'avvio del server
+oppure gli errori di Shutdown e delle funzioni di chiusura uniti con errors.Join.
+Le funzioni di chiusura sono eseguite anche se l'avvio del server non è riuscito.
+*/
+func (lc *Lifecycle) Run() error {
+	return lc.run(lc.server.ListenAndServe, nil)
+}
+
+/*
+RunTLS avvia il server con HTTPS e resta in attesa fino alla chiusura, come Run.
+
+Il certificato è caricato dai file indicati nelle opzioni, creato se SelfSigned è true e i file non esistono,
+e ricaricato quando i file cambiano. Se RedirectAddr non è vuoto, è avviato anche un server HTTP
+che reindirizza le richieste a HTTPS con RedirectHTTPS e si chiude insieme al server principale.
+L'intestazione HSTS va aggiunta con il middleware HSTS.
+
+  lc := webman.NewLifecycle(&http.Server{Addr: ":443", Handler: sm}, 0, nil)
+  err := lc.RunTLS(webman.TLSOptions{CertFile: "cert.pem", KeyFile: "key.pem", SelfSigned: true, RedirectAddr: ":80"})
+
+Restituisce anche l'errore del caricamento o della creazione del certificato.
+*/
+func (lc *Lifecycle) RunTLS(opt TLSOptions) error {
+	var redirect *http.Server
+	if opt.RedirectAddr != "" {
+		redirect = &http.Server{Addr: opt.RedirectAddr, Handler: RedirectHTTPS(lc.server.Addr), ReadHeaderTimeout: 10 * time.Second}
+	}
+	return lc.run(func() error {
+		cfg, err := lc.tlsConfig(opt)
+		if err != nil {
+			return err
+		}
+		lc.server.TLSConfig = cfg
+		served := make(chan error, 2)
+		if redirect != nil {
+			go func() {
+				served <- redirect.ListenAndServe()
+			}()
+			lc.log.Printf("REDIRECT HTTPS AVVIATO: %s\n", redirect.Addr)
+		}
+		go func() {
+			served <- lc.server.ListenAndServeTLS("", "")
+		}()
+		// il primo server che termina chiude anche l'altro
+		return <-served
+	}, redirect)
+}
+
+// run avvia il server con la funzione serve e gestisce la chiusura.
+// Il server redirect, se non è nil, è chiuso prima del server principale.
+func (lc *Lifecycle) run(serve func() error, redirect *http.Server) error {
+	lc.mu.Lock()
+	if lc.started {
+		lc.mu.Unlock()
+		return ErrLifecycleStarted
+	}
+	lc.started = true
+	lc.mu.Unlock()
+
+	// attende i segnali di chiusura
+	sig, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve()
+	}()
+	lc.log.Printf("SERVER AVVIATO: %s\n", lc.server.Addr)
+
+	var errs []error
+	select {
+	case err := <-served:
+		// il server non è partito o si è chiuso da solo
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	case <-sig.Done():
+		lc.log.Print("CHIUSURA: segnale ricevuto\n")
+	case <-lc.stop:
+		lc.log.Print("CHIUSURA: richiesta dall'applicazione\n")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lc.timeout)
+	defer cancel()
+
+	// esegue le funzioni che precedono la chiusura del server
+	lc.mu.Lock()
+	drains := append([]shutdownHook(nil), lc.drains...)
+	lc.mu.Unlock()
+	for _, h := range drains {
+		if err := h.hook(ctx); err != nil {
+			lc.log.Printf("CHIUSURA: %s: %v\n", h.name, err)
+			errs = append(errs, err)
+		}
+	}
+
+	// completa le richieste in corso
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			redirect.Close()
+		}
+	}
+	if err := lc.server.Shutdown(ctx); err != nil {
+		lc.log.Printf("CHIUSURA: richieste non completate entro %s: %v\n", lc.timeout, err)
+		lc.server.Close()
+		errs = append(errs, err)
+	}
+
+	// esegue le funzioni di chiusura nell'ordine di registrazione
+	lc.mu.Lock()
+	hooks := append([]shutdownHook(nil), lc.hooks...)
+	lc.mu.Unlock()
+	for _, h := range hooks {
+		if err := h.hook(ctx); err != nil {
+			lc.log.Printf("CHIUSURA: %s: %v\n", h.name, err)
+			errs = append(errs, err)
+		}
+	}
+
+	lc.log.Print("SERVER CHIUSO\n")
+	return errors.Join(errs...)
+}
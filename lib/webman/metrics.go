@@ -0,0 +1,321 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//MetricsMediaType è il tipo di contenuto del formato di testo di Prometheus restituito dal gestore Metrics.
+const MetricsMediaType string = "text/plain; version=0.0.4; charset=utf-8"
+
+//UnmatchedRoute è il valore dell'etichetta route delle richieste a cui non corrisponde nessun percorso associato.
+const UnmatchedRoute string = "unmatched"
+
+//ErrInvalidMetric è l'errore usato nel panic quando il nome o gli intervalli di una metrica non sono validi.
+var ErrInvalidMetric error = errors.New("invalid metric")
+
+//DefaultMetricsBuckets restituisce i limiti superiori in secondi di default degli intervalli
+//dell'istogramma della durata delle richieste, gli stessi delle librerie client di Prometheus.
+func DefaultMetricsBuckets() []float64 {
+	return []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+}
+
+// metricName è l'espressione regolare dei nomi validi delle metriche, labelName quella delle etichette.
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// requestLabels contiene le etichette delle metriche di una richiesta.
+type requestLabels struct {
+	route  string
+	method string
+	status string
+}
+
+// requestStats contiene il numero di richieste, la somma delle durate e il numero di richieste
+// per ogni intervallo dell'istogramma, l'ultimo per le durate oltre il limite più alto.
+type requestStats struct {
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// gauge contiene una metrica gauge dell'applicazione, con un solo valore o un valore per etichetta.
+type gauge struct {
+	name   string
+	help   string
+	label  string
+	value  func() float64
+	values func() map[string]float64
+}
+
+// ===== Tipo Metrics =====
+
+/*
+Metrics raccoglie le metriche delle richieste servite e quelle definite dall'applicazione
+e le restituisce nel formato di testo di Prometheus, così possono essere lette da Prometheus
+o da altri sistemi di monitoraggio senza librerie esterne.
+
+Le metriche delle richieste sono raccolte dal middleware Instrument:
+
+  http_requests_total            contatore delle richieste completate
+  http_request_duration_seconds  istogramma della durata delle richieste
+  http_requests_in_flight        numero di richieste in corso
+
+Le prime due hanno le etichette route, con il percorso associato alla richiesta (ad esempio /note/{id})
+o UnmatchedRoute, method e status, con il codice di stato della risposta. Il percorso associato
+al posto di quello richiesto limita il numero di serie, che non cresce con gli identificativi delle risorse;
+i metodi non standard hanno l'etichetta method="OTHER".
+Le richieste servite da un percorso associato come radice, ad esempio "/" o "/files/", hanno tutte
+l'etichetta di quel percorso: per distinguere un gruppo di percorsi va associato un percorso
+specifico o con parametri, ad esempio /note/{filtro}, anche allo stesso gestore.
+
+Metrics è un http.Handler che risponde con tutte le metriche:
+
+  metriche := webman.NewMetrics(nil)
+  metriche.GaugeFunc("app_utenti_connessi", "Utenti connessi.", contaUtenti)
+  sm.Use(webman.AccessLog(webman.AccessLogOptions{}), sm.Instrument(metriche))
+  sm.EnlistMethodOK(http.MethodGet, "/metrics", metriche)
+*/
+type Metrics struct {
+	buckets  []float64
+	inFlight atomic.Int64
+	mu       sync.Mutex
+	requests map[requestLabels]*requestStats
+	gauges   map[string]gauge
+}
+
+/*
+NewMetrics restituisce un Metrics con i limiti superiori in secondi specificati per gli intervalli
+dell'istogramma della durata delle richieste, DefaultMetricsBuckets() se nil.
+
+Il metodo genera un panic con l'errore ErrInvalidMetric se i limiti non sono in ordine crescente.
+*/
+func NewMetrics(buckets []float64) *Metrics {
+	if buckets == nil {
+		buckets = DefaultMetricsBuckets()
+	}
+	for i := 1; i < len(buckets); i++ {
+		if !(buckets[i] > buckets[i-1]) {
+			panic(fmt.Errorf("%w: intervalli non in ordine crescente %v", ErrInvalidMetric, buckets))
+		}
+	}
+	return &Metrics{
+		buckets:  append([]float64(nil), buckets...),
+		requests: make(map[requestLabels]*requestStats),
+		gauges:   make(map[string]gauge),
+	}
+}
+
+// addGauge aggiunge la metrica gauge se il nome è valido e non è già usato, altrimenti genera un panic.
+func (m *Metrics) addGauge(g gauge) {
+	if !metricName.MatchString(g.name) || strings.HasPrefix(g.name, "http_request") {
+		panic(fmt.Errorf("%w: nome %q", ErrInvalidMetric, g.name))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.gauges[g.name]; ok {
+		panic(fmt.Errorf("%w: nome %q già usato", ErrInvalidMetric, g.name))
+	}
+	m.gauges[g.name] = g
+}
+
+/*
+GaugeFunc aggiunge una metrica gauge con il nome e la descrizione specificati,
+il cui valore è restituito dalla funzione f a ogni lettura delle metriche.
+
+Il metodo genera un panic con l'errore ErrInvalidMetric se il nome non è valido per Prometheus,
+se è già usato o se inizia con http_request, riservato alle metriche delle richieste.
+*/
+func (m *Metrics) GaugeFunc(name, help string, f func() float64) {
+	m.addGauge(gauge{name: name, help: help, value: f})
+}
+
+/*
+GaugeVecFunc aggiunge una metrica gauge con più valori, ognuno con un valore diverso dell'etichetta label,
+restituiti dalla funzione f a ogni lettura delle metriche, ad esempio le note per stato:
+
+  metriche.GaugeVecFunc("app_note", "Note per stato.", "stato", func() map[string]float64 {
+    return map[string]float64{"fatte": ..., "dafare": ...}
+  })
+
+Il metodo genera un panic con l'errore ErrInvalidMetric come GaugeFunc o se il nome dell'etichetta non è valido.
+*/
+func (m *Metrics) GaugeVecFunc(name, help, label string, f func() map[string]float64) {
+	if !labelName.MatchString(label) || strings.HasPrefix(label, "__") {
+		panic(fmt.Errorf("%w: etichetta %q", ErrInvalidMetric, label))
+	}
+	m.addGauge(gauge{name: name, help: help, label: label, values: f})
+}
+
+// observe registra una richiesta completata con le etichette e la durata specificate.
+func (m *Metrics) observe(l requestLabels, d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(m.buckets, s)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.requests[l]
+	if !ok {
+		st = &requestStats{buckets: make([]uint64, len(m.buckets)+1)}
+		m.requests[l] = st
+	}
+	st.count++
+	st.sum += s
+	st.buckets[i]++
+}
+
+// metricMethod restituisce il metodo per l'etichetta method, "OTHER" per i metodi non standard.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// escapeLabel restituisce il valore di un'etichetta con le sequenze di escape del formato di testo.
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// escapeHelp restituisce la descrizione di una metrica con le sequenze di escape del formato di testo.
+var escapeHelp = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace
+
+// formatValue restituisce il valore di una metrica nel formato di testo.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeMetricHeader scrive la descrizione e il tipo di una metrica.
+func writeMetricHeader(b *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+// writeRequests scrive le metriche delle richieste.
+func (m *Metrics) writeRequests(b *bytes.Buffer) {
+	m.mu.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	stats := make(map[requestLabels]requestStats, len(m.requests))
+	for l, st := range m.requests {
+		labels = append(labels, l)
+		stats[l] = requestStats{count: st.count, sum: st.sum, buckets: append([]uint64(nil), st.buckets...)}
+	}
+	m.mu.Unlock()
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	format := func(l requestLabels) string {
+		return fmt.Sprintf(`method="%s",route="%s",status="%s"`, l.method, escapeLabel(l.route), l.status)
+	}
+
+	writeMetricHeader(b, "http_requests_total", "Richieste HTTP completate per percorso, metodo e codice di stato.", "counter")
+	for _, l := range labels {
+		fmt.Fprintf(b, "http_requests_total{%s} %d\n", format(l), stats[l].count)
+	}
+
+	writeMetricHeader(b, "http_request_duration_seconds", "Durata in secondi delle richieste HTTP per percorso, metodo e codice di stato.", "histogram")
+	for _, l := range labels {
+		st, lbl := stats[l], format(l)
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += st.buckets[i]
+			fmt.Fprintf(b, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", lbl, formatValue(le), cumulative)
+		}
+		fmt.Fprintf(b, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", lbl, st.count)
+		fmt.Fprintf(b, "http_request_duration_seconds_sum{%s} %s\n", lbl, formatValue(st.sum))
+		fmt.Fprintf(b, "http_request_duration_seconds_count{%s} %d\n", lbl, st.count)
+	}
+
+	writeMetricHeader(b, "http_requests_in_flight", "Richieste HTTP in corso.", "gauge")
+	fmt.Fprintf(b, "http_requests_in_flight %d\n", m.inFlight.Load())
+}
+
+// writeGauges scrive le metriche gauge dell'applicazione in ordine di nome.
+func (m *Metrics) writeGauges(b *bytes.Buffer) {
+	m.mu.Lock()
+	gauges := make([]gauge, 0, len(m.gauges))
+	for _, g := range m.gauges {
+		gauges = append(gauges, g)
+	}
+	m.mu.Unlock()
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].name < gauges[j].name })
+
+	for _, g := range gauges {
+		writeMetricHeader(b, g.name, g.help, "gauge")
+		if g.value != nil {
+			fmt.Fprintf(b, "%s %s\n", g.name, formatValue(g.value()))
+			continue
+		}
+		values := g.values()
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(b, "%s{%s=\"%s\"} %s\n", g.name, g.label, escapeLabel(k), formatValue(values[k]))
+		}
+	}
+}
+
+/*
+ServeHTTP risponde con tutte le metriche nel formato di testo di Prometheus (MetricsMediaType).
+Le funzioni delle metriche gauge sono eseguite durante la risposta.
+*/
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	m.writeRequests(&b)
+	m.writeGauges(&b)
+	w.Header().Set("Content-Type", MetricsMediaType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b.Bytes())
+}
+
+/*
+Instrument restituisce un middleware che registra in m le metriche delle richieste:
+il numero, la durata e le richieste in corso, con il percorso associato, il metodo e il codice di stato.
+
+Il middleware va aggiunto con il metodo Use dello stesso ServerManager, dopo AccessLog, così misura
+anche il lavoro degli altri middleware, comprese le risposte di errore come 401, 404 o 429:
+
+  sm.Use(webman.AccessLog(webman.AccessLogOptions{}), sm.Instrument(metriche))
+*/
+func (sm *ServerManager) Instrument(m *Metrics) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m.inFlight.Add(1)
+			defer m.inFlight.Add(-1)
+
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r)
+			// l'azione è quella trovata da ServeHTTP e usata da dispatch, senza una nuova ricerca
+			route := UnmatchedRoute
+			if action := sm.servedAction(r); !action.statusReply {
+				route = action.path
+			}
+			m.observe(requestLabels{route: route, method: metricMethod(r.Method), status: strconv.Itoa(rw.statusCode())}, time.Since(start))
+		})
+	}
+}
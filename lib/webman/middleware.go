@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"net/http"
+	"time"
+)
+
+/*
+Middleware è il tipo funzione che avvolge un gestore di richiesta con un altro gestore.
+
+Il gestore restituito può eseguire operazioni prima e dopo aver affidato la richiesta
+al gestore next, oppure rispondere direttamente senza chiamarlo.
+*/
+type Middleware func(next http.Handler) http.Handler
+
+/*
+Chain restituisce il gestore hler avvolto dai middleware specificati.
+
+Il primo middleware è il più esterno: riceve la richiesta per primo e completa la risposta per ultimo.
+Ad esempio Chain(h, a, b) equivale a a(b(h)).
+
+Con Chain puoi associare middleware a un singolo percorso:
+
+  sm.EnlistMethodOK(http.MethodPost, "/note", webman.Chain(hler, auth, limite))
+*/
+func Chain(hler http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		hler = mw[i](hler)
+	}
+	return hler
+}
+
+/*
+Use aggiunge middleware applicati a tutte le richieste ricevute dal ServerManager,
+comprese quelle a cui risponde un gestore di stato (ad esempio 404 o 405).
+
+I middleware sono eseguiti nell'ordine in cui sono aggiunti, il primo è il più esterno,
+e precedono la ricerca del gestore: i parametri di percorso non sono ancora disponibili.
+I middleware associati a un percorso con Chain sono eseguiti dopo quelli aggiunti con Use.
+
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+*/
+func (sm *ServerManager) Use(mw ...Middleware) {
+	if !sm.IsReady() {
+		panic(ErrServerManagerNotReady)
+	}
+	sm.middlewares = append(sm.middlewares, mw...)
+	sm.chain = Chain(http.HandlerFunc(sm.safeDispatch), sm.middlewares...)
+}
+
+/*
+Timeout restituisce un middleware che risponde con il codice 503 e il messaggio specificato
+se il gestore next non completa la risposta entro la durata d.
+
+Il middleware usa http.TimeoutHandler: il contesto della richiesta è annullato allo scadere
+della durata e il gestore next non può usare http.Flusher o http.Hijacker.
+*/
+func Timeout(d time.Duration, message string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, message)
+	}
+}
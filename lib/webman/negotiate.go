@@ -0,0 +1,142 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ===== Tipo mediaRange =====
+
+// mediaRange rappresenta un tipo di contenuto nel valore Accept dell'intestazione
+// oppure un tipo di contenuto offerto dal server.
+type mediaRange struct {
+	mainType string
+	subType  string
+	params   map[string]string
+	q        float64
+}
+
+// parseMediaType analizza un tipo di contenuto nella forma tipo/sottotipo;param=valore.
+// Il parametro q è restituito a parte con il valore 1 se assente.
+func parseMediaType(s string) (mr mediaRange, ok bool) {
+	parts := strings.Split(s, ";")
+	full := strings.ToLower(strings.TrimSpace(parts[0]))
+	slash := strings.IndexByte(full, '/')
+	if slash <= 0 || slash == len(full)-1 {
+		return mr, false
+	}
+	mr.mainType, mr.subType, mr.q = full[:slash], full[slash+1:], 1
+	if mr.mainType == "*" && mr.subType != "*" {
+		return mr, false
+	}
+	for _, p := range parts[1:] {
+		eq := strings.IndexByte(p, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(p[:eq]))
+		value := strings.Trim(strings.TrimSpace(p[eq+1:]), `"`)
+		if name == "q" {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				return mr, false
+			}
+			mr.q = q
+			// i parametri dopo q sono estensioni di Accept e non del tipo
+			break
+		}
+		if mr.params == nil {
+			mr.params = make(map[string]string, 1)
+		}
+		mr.params[name] = value
+	}
+	return mr, true
+}
+
+// parseAccept restituisce i tipi di contenuto del valore Accept ignorando quelli non validi.
+func parseAccept(accept string) (ranges []mediaRange) {
+	for _, s := range strings.Split(accept, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		if mr, ok := parseMediaType(s); ok {
+			ranges = append(ranges, mr)
+		}
+	}
+	return
+}
+
+// specificity restituisce la precisione del tipo di contenuto richiesto se corrisponde all'offerta,
+// altrimenti -1. Un tipo con parametri è più preciso di tipo/sottotipo, che lo è più di tipo/* e */*.
+func (mr mediaRange) specificity(offer mediaRange) int {
+	switch {
+	case mr.mainType == "*":
+		return 0
+	case mr.mainType != offer.mainType:
+		return -1
+	case mr.subType == "*":
+		return 1
+	case mr.subType != offer.subType:
+		return -1
+	}
+	for name, value := range mr.params {
+		if !strings.EqualFold(offer.params[name], value) {
+			return -1
+		}
+	}
+	return 2 + len(mr.params)
+}
+
+/*
+Negotiate restituisce il tipo di contenuto preferito dalla richiesta fra quelli offerti,
+secondo le regole di negoziazione del valore Accept dell'intestazione (RFC 9110, sezione 12.5.1).
+
+Per ogni tipo offerto è considerato il tipo richiesto più preciso che gli corrisponde
+(text/html;level=1 prima di text/html, prima di text/* e infine del tipo che accetta tutto)
+con il relativo peso q.
+È scelto il tipo offerto con il peso maggiore; a parità di peso quello che corrisponde
+al tipo richiesto più preciso e poi il primo nell'ordine dei tipi offerti.
+I tipi con peso q=0 non sono mai scelti.
+
+Se la richiesta non contiene il valore Accept o il valore è vuoto, Negotiate restituisce il primo tipo offerto.
+Se nessun tipo offerto è accettato, restituisce una stringa vuota.
+
+  Negotiate(r, "text/html", "application/json")
+*/
+func Negotiate(r *http.Request, offers ...string) string {
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.Trim(accept, " \t,") == "" {
+		if len(offers) > 0 {
+			return offers[0]
+		}
+		return ""
+	}
+	ranges := parseAccept(accept)
+
+	best, bestQ, bestSpec := "", 0.0, -1
+	for _, o := range offers {
+		offer, ok := parseMediaType(o)
+		if !ok {
+			continue
+		}
+		// cerca il tipo richiesto più preciso che corrisponde all'offerta
+		q, spec := 0.0, -1
+		for _, mr := range ranges {
+			if s := mr.specificity(offer); s > spec {
+				q, spec = mr.q, s
+			}
+		}
+		if spec < 0 || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && spec > bestSpec) {
+			best, bestQ, bestSpec = o, q, spec
+		}
+	}
+	return best
+}
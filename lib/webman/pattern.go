@@ -0,0 +1,215 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//ErrInvalidPattern è l'errore usato nel panic quando un percorso contiene un parametro non valido.
+var ErrInvalidPattern error = errors.New("invalid pattern")
+
+//ErrPatternConflict è l'errore usato nel panic quando un percorso con parametri è in conflitto con uno già associato.
+var ErrPatternConflict error = errors.New("pattern conflict")
+
+//ErrPathParamMissing è l'errore restituito quando la richiesta non contiene il parametro di percorso richiesto.
+var ErrPathParamMissing error = errors.New("path parameter missing")
+
+// ===== Tipo patternSegment =====
+
+// patternSegment rappresenta un segmento di un percorso con parametri.
+// Un segmento fisso ha name vuoto, un parametro {name} corrisponde a un segmento non vuoto
+// e un parametro {name...} corrisponde a tutti i segmenti restanti.
+type patternSegment struct {
+	literal  string
+	name     string
+	wildcard bool
+}
+
+// parsePattern restituisce i segmenti del percorso specificato oppure nil se il percorso non contiene parametri.
+func parsePattern(pattern string) (segs []patternSegment, err error) {
+	if !strings.ContainsAny(pattern, "{}") {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	names := make(map[string]bool, len(parts))
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "{}") {
+			segs = append(segs, patternSegment{literal: part})
+			continue
+		}
+		if len(part) < 3 || part[0] != '{' || part[len(part)-1] != '}' {
+			return nil, fmt.Errorf("%w: %s: il parametro deve occupare l'intero segmento", ErrInvalidPattern, pattern)
+		}
+		seg := patternSegment{name: part[1 : len(part)-1]}
+		if strings.HasSuffix(seg.name, "...") {
+			seg.name, seg.wildcard = strings.TrimSuffix(seg.name, "..."), true
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("%w: %s: il parametro {%s...} deve essere l'ultimo segmento", ErrInvalidPattern, pattern, seg.name)
+			}
+		}
+		if seg.name == "" || strings.ContainsAny(seg.name, "{}") {
+			return nil, fmt.Errorf("%w: %s: nome del parametro non valido", ErrInvalidPattern, pattern)
+		}
+		if names[seg.name] {
+			return nil, fmt.Errorf("%w: %s: il parametro {%s} è ripetuto", ErrInvalidPattern, pattern, seg.name)
+		}
+		names[seg.name] = true
+		segs = append(segs, seg)
+	}
+	return
+}
+
+// matchPattern verifica se il percorso di richiesta corrisponde ai segmenti
+// e restituisce i valori dei parametri.
+func matchPattern(segs []patternSegment, reqPath string) (params map[string]string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(reqPath, "/"), "/")
+	params = make(map[string]string, len(segs))
+	for i, seg := range segs {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case seg.wildcard:
+			params[seg.name] = strings.Join(parts[i:], "/")
+			return params, true
+		case seg.name != "":
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.name] = parts[i]
+		case seg.literal != parts[i]:
+			return nil, false
+		}
+	}
+	return params, (len(parts) == len(segs))
+}
+
+// relazioni fra due percorsi con parametri restituite da comparePatterns
+const (
+	patternDisjoint     = iota // nessuna richiesta corrisponde a entrambi
+	patternEqual               // corrispondono alle stesse richieste
+	patternMoreSpecific        // il primo corrisponde a un sottoinsieme delle richieste del secondo
+	patternLessSpecific        // il secondo corrisponde a un sottoinsieme delle richieste del primo
+	patternConflict            // alcune richieste corrispondono a entrambi e nessuno è più specifico
+)
+
+// comparePatterns confronta i segmenti di due percorsi con parametri.
+func comparePatterns(a, b []patternSegment) int {
+	// aSub e bSub indicano se a è contenuto in b e se b è contenuto in a
+	aSub, bSub := true, true
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) {
+			// un {name...} richiede almeno un segmento, anche vuoto
+			return patternDisjoint
+		}
+		sa, sb := a[i], b[i]
+		if sa.wildcard || sb.wildcard {
+			aSub = aSub && sb.wildcard
+			bSub = bSub && sa.wildcard
+			break
+		}
+		switch {
+		case sa.name == "" && sb.name == "":
+			if sa.literal != sb.literal {
+				return patternDisjoint
+			}
+		case sa.name == "":
+			if sa.literal == "" {
+				return patternDisjoint
+			}
+			bSub = false
+		case sb.name == "":
+			if sb.literal == "" {
+				return patternDisjoint
+			}
+			aSub = false
+		}
+	}
+
+	switch {
+	case aSub && bSub:
+		return patternEqual
+	case aSub:
+		return patternMoreSpecific
+	case bSub:
+		return patternLessSpecific
+	}
+	return patternConflict
+}
+
+// literalPrefix restituisce la parte fissa iniziale di un percorso con parametri.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexByte(pattern, '{'); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// checkPattern analizza il percorso e verifica che non sia in conflitto con quelli già associati.
+//
+// Il metodo genera un panic con l'errore ErrInvalidPattern o ErrPatternConflict.
+func (sm *ServerManager) checkPattern(pattern string) []patternSegment {
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	if segs == nil {
+		return nil
+	}
+	for key, act := range sm.actions {
+		if act.segments == nil || key == pattern {
+			continue
+		}
+		switch comparePatterns(segs, act.segments) {
+		case patternEqual:
+			panic(fmt.Errorf("%w: %s e %s corrispondono alle stesse richieste", ErrPatternConflict, pattern, key))
+		case patternConflict:
+			panic(fmt.Errorf("%w: %s e %s corrispondono ad alcune stesse richieste e nessuno è più specifico", ErrPatternConflict, pattern, key))
+		}
+	}
+	return segs
+}
+
+// ===== Parametri di percorso =====
+
+// pathParamsKey è la chiave dei parametri di percorso nel contesto della richiesta.
+type pathParamsKey struct{}
+
+// withPathParams restituisce la richiesta con i parametri di percorso nel contesto.
+func withPathParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+}
+
+/*
+PathParam restituisce il valore del parametro di percorso con il nome specificato.
+
+Il valore è quello del segmento corrispondente a {name} o dei segmenti corrispondenti a {name...}
+nel percorso associato al gestore. Se la richiesta non contiene il parametro, PathParam restituisce una stringa vuota.
+*/
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+/*
+PathParamInt64 restituisce il valore del parametro di percorso con il nome specificato convertito in int64.
+
+Restituisce l'errore ErrPathParamMissing se la richiesta non contiene il parametro
+oppure l'errore di strconv.ParseInt se il valore non è un numero intero valido.
+*/
+func PathParamInt64(r *http.Request, name string) (int64, error) {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	value, ok := params[name]
+	if !ok {
+		return 0, ErrPathParamMissing
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+//ProblemMediaType è il tipo di contenuto delle risposte di errore in formato JSON (RFC 9457).
+const ProblemMediaType string = "application/problem+json"
+
+// ===== Tipo Problem =====
+
+/*
+Problem descrive l'errore di una richiesta nel formato definito dalla RFC 9457 (Problem Details for HTTP APIs).
+
+  Type      URI che identifica il tipo di problema, "about:blank" se il problema è descritto solo dal codice di stato
+  Title     breve descrizione del tipo di problema, per default il testo del codice di stato
+  Status    codice di stato HTTP della risposta
+  Detail    descrizione del problema specifica per questa richiesta
+  Instance  URI che identifica la richiesta, per default il percorso di richiesta
+
+Extensions contiene eventuali membri aggiuntivi scritti nello stesso oggetto JSON,
+ad esempio gli errori di convalida dei campi; i nomi dei membri standard sono ignorati.
+
+Problem implementa l'interfaccia error.
+*/
+type Problem struct {
+	Type       string         `json:"type,omitempty"`
+	Title      string         `json:"title,omitempty"`
+	Status     int            `json:"status,omitempty"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+//NewProblem restituisce un Problem di tipo "about:blank" con il codice di stato e la descrizione specificati.
+func NewProblem(code int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(code), Status: code, Detail: detail}
+}
+
+//Error restituisce il codice di stato, il titolo e la descrizione del problema.
+func (p *Problem) Error() string {
+	s := strconv.Itoa(p.Status) + " " + p.Title
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+//MarshalJSON restituisce il problema in formato JSON con i membri di Extensions.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	// il tipo locale non ha il metodo MarshalJSON
+	type problem Problem
+	dati, err := json.Marshal(problem(p))
+	if err != nil {
+		return nil, err
+	}
+
+	ext := make(map[string]any, len(p.Extensions))
+	for k, v := range p.Extensions {
+		switch k {
+		case "type", "title", "status", "detail", "instance":
+		default:
+			ext[k] = v
+		}
+	}
+	if len(ext) == 0 {
+		return dati, nil
+	}
+	extDati, err := json.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+	// unisce i due oggetti JSON
+	if len(dati) > 2 {
+		dati = append(dati[:len(dati)-1], ',')
+	} else {
+		dati = dati[:len(dati)-1]
+	}
+	return append(dati, extDati[1:]...), nil
+}
+
+// completed restituisce una copia del problema con i valori di default dei campi non impostati.
+func (p *Problem) completed(r *http.Request) *Problem {
+	cp := *p
+	if cp.Status == 0 {
+		cp.Status = http.StatusInternalServerError
+	}
+	if cp.Type == "" {
+		cp.Type = "about:blank"
+	}
+	if (cp.Title == "") && (cp.Type == "about:blank") {
+		cp.Title = http.StatusText(cp.Status)
+	}
+	if (cp.Instance == "") && (r != nil) {
+		cp.Instance = r.URL.Path
+	}
+	return &cp
+}
+
+// problemKey è la chiave del problema nel contesto della richiesta.
+type problemKey struct{}
+
+/*
+RequestProblem restituisce il problema della risposta in corso, se la richiesta è stata affidata
+a un gestore di stato dal metodo ReplyProblem o ReplyStatus, altrimenti nil.
+
+I gestori di stato registrati con EnlistStatusReply possono così rispondere con i dettagli del problema:
+
+  sm.EnlistStatusReply(http.StatusNotFound, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    p := webman.RequestProblem(r)
+    pagine.ExecuteTemplate(w, "errore.html", p)
+  }))
+*/
+func RequestProblem(r *http.Request) *Problem {
+	p, _ := r.Context().Value(problemKey{}).(*Problem)
+	return p
+}
+
+// problemOffers sono i tipi di contenuto delle risposte di errore in ordine di preferenza.
+var problemOffers = []string{ProblemMediaType, "application/json", "text/html", "text/plain"}
+
+// problemPage è il template della risposta di errore in formato HTML.
+var problemPage = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+{{if .Detail}}<p>{{.Detail}}</p>
+{{end}}{{if .Instance}}<p><small>{{.Instance}}</small></p>
+{{end}}</body>
+</html>
+`))
+
+/*
+WriteProblem risponde con il problema specificato nel formato preferito dalla richiesta
+secondo le regole della funzione Negotiate:
+
+  application/problem+json   oggetto JSON definito dalla RFC 9457 (tipo di default)
+  application/json           lo stesso oggetto con il tipo di contenuto richiesto
+  text/html                  pagina HTML con codice, titolo e descrizione
+  text/plain                 testo nel formato di WriteStatus, usato anche se la richiesta non accetta nessun formato
+
+Se il problema non ha codice di stato, è usato il codice 500; se non ha Instance è usato il percorso di richiesta.
+A differenza del metodo ReplyProblem di ServerManager, WriteProblem non usa i gestori di stato.
+*/
+func WriteProblem(p *Problem, w http.ResponseWriter, r *http.Request) {
+	p = p.completed(r)
+	w.Header().Add("Vary", "Accept")
+
+	switch mt := Negotiate(r, problemOffers...); mt {
+	case ProblemMediaType, "application/json":
+		dati, err := json.Marshal(p)
+		if err != nil {
+			// estensioni non serializzabili
+			dati, _ = json.Marshal(Problem{Type: p.Type, Title: p.Title, Status: p.Status, Detail: p.Detail, Instance: p.Instance})
+		}
+		w.Header().Set("Content-Type", mt+"; charset=utf-8")
+		w.WriteHeader(p.Status)
+		w.Write(dati)
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(p.Status)
+		problemPage.Execute(w, p)
+	default:
+		message := p.Detail
+		if message == "" {
+			message = p.Title
+		}
+		WriteStatus(p.Status, message, p.Instance, w)
+	}
+}
+
+/*
+ReplyProblem risponde con il gestore di stato associato al codice del problema specificato
+con il metodo EnlistStatusReply. Il gestore può leggere il problema con la funzione RequestProblem.
+Se per il codice non c'è un gestore di stato, risponde direttamente con la funzione WriteProblem.
+
+Se il problema non ha codice di stato, è usato il codice 500; se non ha Instance è usato il percorso di richiesta.
+Come per ReplyStatus, se la risposta è già iniziata il problema è solo scritto nel log.
+*/
+func (sm *ServerManager) ReplyProblem(p *Problem, w http.ResponseWriter, r *http.Request) {
+	p = p.completed(r)
+	if !sm.IsReady() {
+		if !responseStarted(w) {
+			WriteProblem(p, w, r)
+		}
+		return
+	}
+	// scrive nel log lo stato e il messaggio
+	sm.log.Printf("RISPOSTA: STATO %d [%s]\n", p.Status, RequestID(r))
+	sm.log.Printf("MESSAGGIO: [%s]\n", p.Detail)
+	if responseStarted(w) {
+		sm.log.Printf("RISPOSTA GIÀ INIZIATA [%s]: STATO %d NON INVIATO\n", RequestID(r), p.Status)
+		return
+	}
+	// trova l'azione con il gestore risposta stato
+	a := sm.actions[statusKey(p.Status)]
+	if a.isStatusReply(p.Status) && a.handler != nil {
+		// se c'è, lo usa con il problema nel contesto
+		a.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), problemKey{}, p)))
+	} else {
+		// se non c'è, risponde direttamente
+		WriteProblem(p, w, r)
+	}
+}
+
+// writeDefaultProblem risponde con il problema della richiesta se ha il codice specificato,
+// altrimenti con un nuovo problema; detail è la descrizione usata se il problema non ne ha una.
+func writeDefaultProblem(code int, detail string, w http.ResponseWriter, r *http.Request) {
+	p := RequestProblem(r)
+	if (p == nil) || (p.Status != code) {
+		p = NewProblem(code, detail)
+	} else if p.Detail == "" {
+		cp := *p
+		cp.Detail = detail
+		p = &cp
+	}
+	WriteProblem(p, w, r)
+}
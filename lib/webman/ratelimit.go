@@ -0,0 +1,243 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultRateLimitClients è il numero massimo di default di client di cui il middleware RateLimit conserva lo stato.
+const DefaultRateLimitClients int = 10000
+
+//ErrInvalidRateLimit è l'errore usato nel panic quando le impostazioni del middleware RateLimit non sono valide.
+var ErrInvalidRateLimit error = errors.New("invalid rate limit")
+
+/*
+RateLimitOptions contiene le impostazioni del middleware RateLimit.
+
+  Rate            richieste al secondo concesse a ogni client nel lungo periodo, ad esempio 0.5 per una ogni due secondi
+  Burst           richieste consecutive concesse a un client inattivo, almeno 1
+  Key             funzione che restituisce la chiave del client, ad esempio l'utente autenticato;
+                  se nil o se restituisce una stringa vuota è usato l'indirizzo IP del client
+  TrustedProxies  indirizzi IP o reti CIDR dei proxy fidati, di cui è letto il valore X-Forwarded-For
+  MaxClients      numero massimo di client di cui è conservato lo stato, DefaultRateLimitClients se minore o uguale a zero
+*/
+type RateLimitOptions struct {
+	Rate           float64
+	Burst          int
+	Key            func(r *http.Request) string
+	TrustedProxies []string
+	MaxClients     int
+}
+
+// ===== Tipo rateLimiter =====
+
+// tokenBucket contiene i gettoni disponibili di un client all'istante dell'ultima richiesta.
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter contiene i secchi dei client in ordine di ultima richiesta, il più recente in testa.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	max     int
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	recent  *list.List
+}
+
+// allow consuma un gettone del client con la chiave specificata e restituisce true
+// oppure, se non ci sono gettoni disponibili, false e l'attesa prima del prossimo gettone.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.evictIdle(now)
+	var b *tokenBucket
+	if e, ok := rl.clients[key]; ok {
+		b = e.Value.(*tokenBucket)
+		b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+		b.last = now
+		rl.recent.MoveToFront(e)
+	} else {
+		b = &tokenBucket{key: key, tokens: rl.burst, last: now}
+		rl.clients[key] = rl.recent.PushFront(b)
+		if rl.recent.Len() > rl.max {
+			// elimina il client meno recente
+			rl.remove(rl.recent.Back())
+		}
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evictIdle elimina i client meno recenti il cui secchio è di nuovo pieno:
+// il loro stato coincide con quello di un client nuovo.
+func (rl *rateLimiter) evictIdle(now time.Time) {
+	refill := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for e := rl.recent.Back(); e != nil; e = rl.recent.Back() {
+		if now.Sub(e.Value.(*tokenBucket).last) < refill {
+			return
+		}
+		rl.remove(e)
+	}
+}
+
+// remove elimina il client dell'elemento specificato.
+func (rl *rateLimiter) remove(e *list.Element) {
+	rl.recent.Remove(e)
+	delete(rl.clients, e.Value.(*tokenBucket).key)
+}
+
+// ===== Indirizzo del client =====
+
+// parseProxies restituisce le reti dei proxy fidati specificati come indirizzi IP o reti CIDR.
+func parseProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("%w: proxy %s", ErrInvalidRateLimit, p)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: proxy %s", ErrInvalidRateLimit, p)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// trusted restituisce true se l'indirizzo appartiene a una delle reti specificate.
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+clientIP restituisce l'indirizzo IP del client della richiesta.
+
+Se la connessione proviene da un proxy fidato, l'elenco X-Forwarded-For è letto da destra a sinistra,
+perché solo gli indirizzi aggiunti dai proxy fidati sono affidabili: il primo indirizzo non fidato è il client.
+Se anche tutti gli indirizzi dell'elenco sono fidati, è restituito il primo.
+*/
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !trusted(addr, proxies) {
+		return addr.String()
+	}
+
+	var forwarded []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// elenco alterato: gli indirizzi a sinistra non sono affidabili
+			break
+		}
+		addr = hop.Unmap()
+		if !trusted(addr, proxies) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+/*
+RateLimit restituisce un middleware che limita le richieste di ogni client con l'algoritmo token bucket:
+ogni client ha un secchio con al massimo Burst gettoni, che si riempie di Rate gettoni al secondo,
+e ogni richiesta consuma un gettone. Senza gettoni disponibili la risposta è 429 TooManyRequests
+con il valore "Retry-After" nell'intestazione, inviata con il metodo ReplyStatus,
+quindi con il gestore di stato associato al codice se presente.
+
+Il client è identificato dalla funzione Key oppure dall'indirizzo IP; dietro un proxy inverso
+specifica il suo indirizzo in TrustedProxies, altrimenti tutte le richieste sembrano dello stesso client.
+
+Per limitare solo alcuni percorsi usa il middleware con la funzione Chain:
+i percorsi avvolti dallo stesso middleware condividono i secchi dei client.
+
+  limite := sm.RateLimit(webman.RateLimitOptions{Rate: 1, Burst: 10})
+  sm.EnlistMethodOK(http.MethodPost, "/note", webman.Chain(hler, limite))
+
+Lo stato di un client è eliminato quando il suo secchio torna pieno. Per limitare la memoria usata,
+se i client sono più di MaxClients è eliminato quello inattivo da più tempo, che alla richiesta successiva
+riparte con il secchio pieno.
+
+Il metodo genera un panic con l'errore ErrInvalidRateLimit se Rate non è maggiore di zero o se un proxy fidato non è valido.
+*/
+func (sm *ServerManager) RateLimit(opt RateLimitOptions) Middleware {
+	if !(opt.Rate > 0) {
+		panic(fmt.Errorf("%w: rate %v", ErrInvalidRateLimit, opt.Rate))
+	}
+	proxies, err := parseProxies(opt.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	rl := &rateLimiter{
+		rate:    opt.Rate,
+		burst:   math.Max(1, float64(opt.Burst)),
+		max:     opt.MaxClients,
+		clients: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+	if rl.max <= 0 {
+		rl.max = DefaultRateLimitClients
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var key string
+			if opt.Key != nil {
+				key = opt.Key(r)
+			}
+			if key == "" {
+				key = "ip:" + clientIP(r, proxies)
+			}
+			ok, wait := rl.allow(key, time.Now())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				sm.ReplyStatus(http.StatusTooManyRequests, "Troppe richieste, riprova più tardi.", w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
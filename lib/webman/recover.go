@@ -0,0 +1,82 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// invalidHeaders elenca i valori dell'intestazione eliminati prima di rispondere
+// con il codice 500 a una richiesta il cui gestore ha generato un panic.
+var invalidHeaders = []string{"Content-Length", "Content-Disposition", "ETag", "Last-Modified"}
+
+// responseStarted restituisce true se l'intestazione della risposta è già stata inviata.
+// Il controllo è possibile solo se w è, o avvolge con il metodo Unwrap, un responseWriter.
+func responseStarted(w http.ResponseWriter) bool {
+	for w != nil {
+		switch rw := w.(type) {
+		case *responseWriter:
+			return rw.started()
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// safeDispatch affida la richiesta a dispatch recuperando gli eventuali panic dei gestori.
+func (sm *ServerManager) safeDispatch(w http.ResponseWriter, r *http.Request) {
+	rw := newResponseWriter(w)
+	defer sm.recoverPanic(rw, r)
+	sm.dispatch(rw, r)
+}
+
+/*
+recoverPanic recupera il panic di un gestore, scrive nel log il valore e lo stack
+con l'identificativo della richiesta e risponde con il gestore di stato del codice 500.
+
+Se la risposta è già iniziata non scrive una seconda intestazione: la risposta resta incompleta.
+Il panic con il valore http.ErrAbortHandler è rigenerato per interrompere la risposta come previsto da net/http.
+
+La funzione deve essere chiamata direttamente con defer.
+*/
+func (sm *ServerManager) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+	sm.log.Printf("PANIC [%s]: %v\n%s", RequestID(r), p, debug.Stack())
+	if responseStarted(w) {
+		sm.log.Printf("RISPOSTA GIÀ INIZIATA [%s]: STATO %d NON INVIATO\n", RequestID(r), http.StatusInternalServerError)
+		return
+	}
+	for _, k := range invalidHeaders {
+		w.Header().Del(k)
+	}
+	sm.replyServerError(w, r)
+}
+
+// replyServerError risponde con il gestore di stato del codice 500
+// e usa DefaultServerErrorReply se anche quel gestore genera un panic.
+func (sm *ServerManager) replyServerError(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			sm.log.Printf("PANIC [%s]: gestore di stato %d: %v\n", RequestID(r), http.StatusInternalServerError, p)
+			if !responseStarted(w) {
+				DefaultServerErrorReply(w, r)
+			}
+		}
+	}()
+	sm.ReplyStatus(http.StatusInternalServerError, "Errore interno del server.", w, r)
+}
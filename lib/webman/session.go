@@ -0,0 +1,209 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//DefaultSessionCookie è il nome di default del cookie di sessione.
+const DefaultSessionCookie string = "session"
+
+//DefaultSessionMaxAge è la durata massima di default di una sessione.
+const DefaultSessionMaxAge time.Duration = 12 * time.Hour
+
+//ErrInvalidSession è l'errore restituito quando il cookie di sessione non è valido o la sessione è scaduta.
+var ErrInvalidSession error = errors.New("invalid session")
+
+/*
+SessionOptions contiene le impostazioni di SessionAuth.
+
+  CookieName  nome del cookie di sessione, DefaultSessionCookie se vuoto
+  MaxAge      durata massima di una sessione dall'accesso, DefaultSessionMaxAge se minore o uguale a zero
+  Key         chiave AES di 16, 24 o 32 byte con cui è cifrato l'identificativo nel cookie;
+              se nil è generata una chiave casuale
+  Secure      se true il cookie è inviato solo con HTTPS, come avviene comunque per le richieste su TLS
+*/
+type SessionOptions struct {
+	CookieName string
+	MaxAge     time.Duration
+	Key        []byte
+	Secure     bool
+}
+
+// session contiene il nome dell'utente e la scadenza di una sessione.
+type session struct {
+	name    string
+	expires time.Time
+}
+
+// ===== Tipo SessionAuth =====
+
+/*
+SessionAuth autentica le richieste con un cookie di sessione creato dal metodo Login,
+ad esempio dopo la verifica di nome e password in una pagina di accesso.
+
+Le sessioni sono conservate in memoria e terminano alla chiusura del server.
+Il cookie contiene l'identificativo casuale della sessione cifrato e autenticato con AES-GCM:
+un cookie alterato o creato senza la chiave è rifiutato senza cercare la sessione.
+Il cookie è HttpOnly e SameSite=Lax, quindi non è leggibile dagli script e non è inviato
+con i moduli POST di altri siti.
+*/
+type SessionAuth struct {
+	cookie   string
+	maxAge   time.Duration
+	secure   bool
+	aead     cipher.AEAD
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+/*
+NewSessionAuth restituisce un SessionAuth con le impostazioni specificate.
+Restituisce un errore se la chiave non ha una lunghezza valida per AES.
+*/
+func NewSessionAuth(opt SessionOptions) (*SessionAuth, error) {
+	sa := &SessionAuth{cookie: opt.CookieName, maxAge: opt.MaxAge, secure: opt.Secure, sessions: make(map[string]session)}
+	if sa.cookie == "" {
+		sa.cookie = DefaultSessionCookie
+	}
+	if sa.maxAge <= 0 {
+		sa.maxAge = DefaultSessionMaxAge
+	}
+	key := opt.Key
+	if key == nil {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if sa.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// seal restituisce il valore del cookie con l'identificativo di sessione cifrato.
+func (sa *SessionAuth) seal(id []byte) string {
+	nonce := make([]byte, sa.aead.NonceSize(), sa.aead.NonceSize()+len(id)+sa.aead.Overhead())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(sa.aead.Seal(nonce, nonce, id, []byte(sa.cookie)))
+}
+
+// open restituisce l'identificativo di sessione del valore del cookie.
+func (sa *SessionAuth) open(value string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if (err != nil) || (len(data) < sa.aead.NonceSize()) {
+		return "", ErrInvalidSession
+	}
+	nonce, sealed := data[:sa.aead.NonceSize()], data[sa.aead.NonceSize():]
+	id, err := sa.aead.Open(nil, nonce, sealed, []byte(sa.cookie))
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+	return string(id), nil
+}
+
+// sessionID restituisce l'identificativo della sessione del cookie della richiesta, vuoto se assente o non valido.
+func (sa *SessionAuth) sessionID(r *http.Request) string {
+	c, err := r.Cookie(sa.cookie)
+	if err != nil {
+		return ""
+	}
+	id, _ := sa.open(c.Value)
+	return id
+}
+
+// setCookie imposta il cookie di sessione nella risposta; con maxAge negativo lo elimina.
+func (sa *SessionAuth) setCookie(w http.ResponseWriter, r *http.Request, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     sa.cookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   sa.secure || (r.TLS != nil),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge / time.Second),
+	}
+	if maxAge < 0 {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+/*
+Login crea una nuova sessione per l'utente specificato e imposta il cookie nella risposta.
+L'eventuale sessione precedente della richiesta è eliminata, così un identificativo
+ottenuto prima dell'accesso non può essere riutilizzato (session fixation).
+Le sessioni scadute sono eliminate.
+*/
+func (sa *SessionAuth) Login(w http.ResponseWriter, r *http.Request, name string) {
+	id := make([]byte, 32)
+	rand.Read(id)
+	now := time.Now()
+
+	sa.mu.Lock()
+	if old := sa.sessionID(r); old != "" {
+		delete(sa.sessions, old)
+	}
+	for k, s := range sa.sessions {
+		if now.After(s.expires) {
+			delete(sa.sessions, k)
+		}
+	}
+	sa.sessions[string(id)] = session{name: name, expires: now.Add(sa.maxAge)}
+	sa.mu.Unlock()
+
+	sa.setCookie(w, r, sa.seal(id), sa.maxAge)
+}
+
+//Logout elimina la sessione della richiesta e il cookie nella risposta.
+func (sa *SessionAuth) Logout(w http.ResponseWriter, r *http.Request) {
+	if id := sa.sessionID(r); id != "" {
+		sa.mu.Lock()
+		delete(sa.sessions, id)
+		sa.mu.Unlock()
+	}
+	sa.setCookie(w, r, "", -1)
+}
+
+//Authenticate restituisce il principal della sessione del cookie della richiesta, se presente.
+func (sa *SessionAuth) Authenticate(r *http.Request) (*Principal, error) {
+	c, err := r.Cookie(sa.cookie)
+	if err != nil {
+		return nil, nil
+	}
+	id, err := sa.open(c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	s, ok := sa.sessions[id]
+	if ok && time.Now().After(s.expires) {
+		delete(sa.sessions, id)
+		ok = false
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: sessione terminata o scaduta", ErrInvalidSession)
+	}
+	return &Principal{Name: s.name, Scheme: "session"}, nil
+}
+
+//Challenge restituisce una stringa vuota: le sessioni non hanno uno schema "WWW-Authenticate".
+func (sa *SessionAuth) Challenge() string {
+	return ""
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//StaticImmutableCache è il valore "Cache-Control" dei file richiesti con l'impronta del contenuto nel nome.
+const StaticImmutableCache string = "public, max-age=31536000, immutable"
+
+// fingerprintSize è il numero di caratteri esadecimali dell'impronta nei nomi dei file.
+const fingerprintSize int = 16
+
+// errStaticDir è l'errore restituito quando il nome richiesto è una cartella.
+var errStaticDir error = errors.New("is a directory")
+
+/*
+StaticOptions contiene le impostazioni di Static.
+
+  Prefix  prefisso dei percorsi delle richieste tolto per ottenere il nome del file, "/" se vuoto;
+          ad esempio con "/static/" il percorso /static/img/logo.png corrisponde al file img/logo.png
+  MaxAge  durata per cui il browser può usare i file richiesti senza impronta senza verificarli;
+          se minore o uguale a zero il browser li verifica a ogni uso con l'ETag ("no-cache")
+*/
+type StaticOptions struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// staticFile contiene il contenuto di un file e la sua impronta.
+type staticFile struct {
+	data     []byte
+	hash     string
+	modified time.Time
+	size     int64
+}
+
+// ===== Tipo Static =====
+
+/*
+Static serve i file di un file system, ad esempio un embed.FS compilato nell'eseguibile
+oppure una cartella aperta con os.DirFS durante lo sviluppo.
+
+Il metodo URL restituisce il percorso di un file con l'impronta del contenuto nel nome,
+ad esempio /files/stili.3f2a9c0b1d4e5f60.css: il browser può conservare questi file
+per un anno senza verificarli, perché quando il file cambia cambia anche il percorso.
+I file richiesti senza impronta, o con un'impronta non più valida, sono serviti con la durata MaxAge.
+Tutti i file hanno l'ETag dell'impronta e le richieste condizionali e parziali sono gestite con http.ServeContent.
+
+I file sono letti in memoria alla prima richiesta; a ogni richiesta successiva sono controllate
+la data e la dimensione del file e, se sono cambiate, il file è letto di nuovo.
+Le cartelle non sono elencate e le richieste dei file che non esistono ricevono la risposta 404
+del ServerManager con ReplyStatus, come quelle degli altri percorsi.
+
+  statici := sm.Static(pubblico, webman.StaticOptions{})
+  sm.EnlistMethodOK(http.MethodGet, "/files/", statici)
+  t.Funcs(template.FuncMap{"asset": statici.URL})
+
+  <link rel="stylesheet" href="{{asset "/files/stili.css"}}">
+*/
+type Static struct {
+	sm     *ServerManager
+	fsys   fs.FS
+	prefix string
+	cache  string
+	mu     sync.Mutex
+	files  map[string]*staticFile
+}
+
+//Static restituisce uno Static che serve i file del file system specificato
+//e risponde alle richieste dei file che non esistono con il gestore di stato del codice 404 di sm.
+func (sm *ServerManager) Static(fsys fs.FS, opt StaticOptions) *Static {
+	s := &Static{sm: sm, fsys: fsys, prefix: opt.Prefix, cache: "no-cache", files: make(map[string]*staticFile)}
+	if s.prefix == "" {
+		s.prefix = "/"
+	}
+	if opt.MaxAge > 0 {
+		s.cache = "public, max-age=" + strconv.FormatInt(int64(opt.MaxAge/time.Second), 10)
+	}
+	return s
+}
+
+// file restituisce il file con il nome specificato, rileggendolo se è cambiato.
+func (s *Static) file(name string) (*staticFile, error) {
+	fi, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, errStaticDir
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok && f.modified.Equal(fi.ModTime()) && (f.size == fi.Size()) {
+		return f, nil
+	}
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	f := &staticFile{data: data, hash: hex.EncodeToString(sum[:])[:fingerprintSize], modified: fi.ModTime(), size: fi.Size()}
+	s.files[name] = f
+	return f, nil
+}
+
+// fileName restituisce il nome del file del percorso specificato, vuoto se il percorso non ha il prefisso.
+func (s *Static) fileName(urlPath string) string {
+	if !strings.HasPrefix(urlPath, s.prefix) {
+		return ""
+	}
+	name := path.Clean("/" + strings.TrimPrefix(urlPath, s.prefix))[1:]
+	if !fs.ValidPath(name) || (name == ".") {
+		return ""
+	}
+	return name
+}
+
+// fingerprinted restituisce il nome senza l'impronta e l'impronta, oppure due stringhe vuote
+// se il nome non contiene un'impronta.
+func fingerprinted(name string) (string, string) {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if isFingerprint(strings.TrimPrefix(ext, ".")) {
+		// file senza estensione
+		return stem, ext[1:]
+	}
+	i := strings.LastIndexByte(stem, '.')
+	if (i < 0) || !isFingerprint(stem[i+1:]) {
+		return "", ""
+	}
+	return stem[:i] + ext, stem[i+1:]
+}
+
+// isFingerprint restituisce true se la stringa ha il formato di un'impronta.
+func isFingerprint(s string) bool {
+	if len(s) != fingerprintSize {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+/*
+URL restituisce il percorso con l'impronta del contenuto del file del percorso specificato,
+ad esempio /files/stili.3f2a9c0b1d4e5f60.css per /files/stili.css.
+Restituisce il percorso specificato se non corrisponde a un file.
+*/
+func (s *Static) URL(urlPath string) string {
+	name := s.fileName(urlPath)
+	if name == "" {
+		return urlPath
+	}
+	f, err := s.file(name)
+	if err != nil {
+		return urlPath
+	}
+	dir, base := path.Split(name)
+	if ext := path.Ext(base); ext != "" {
+		base = strings.TrimSuffix(base, ext) + "." + f.hash + ext
+	} else {
+		base += "." + f.hash
+	}
+	return s.prefix + dir + base
+}
+
+//ServeHTTP serve il file del percorso richiesto, oppure risponde con ReplyStatus e il codice 404 se il file non esiste.
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := s.fileName(r.URL.Path)
+	if name == "" {
+		s.sm.ReplyStatus(http.StatusNotFound, "", w, r)
+		return
+	}
+	cache := s.cache
+	f, err := s.file(name)
+	if err != nil {
+		base, hash := fingerprinted(name)
+		if base == "" {
+			s.sm.ReplyStatus(http.StatusNotFound, "", w, r)
+			return
+		}
+		if f, err = s.file(base); err != nil {
+			s.sm.ReplyStatus(http.StatusNotFound, "", w, r)
+			return
+		}
+		if hash == f.hash {
+			cache = StaticImmutableCache
+		}
+		name = base
+	}
+
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", MakeETag(f.hash, false))
+	http.ServeContent(w, r, path.Base(name), f.modified, bytes.NewReader(f.data))
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultCertReloadInterval è l'intervallo minimo di default fra due verifiche dei file del certificato.
+const DefaultCertReloadInterval time.Duration = 10 * time.Second
+
+//DefaultCertValidity è la durata di validità dei certificati autofirmati.
+const DefaultCertValidity time.Duration = 365 * 24 * time.Hour
+
+//DefaultHSTSMaxAge è la durata di default per cui il browser deve usare solo HTTPS.
+const DefaultHSTSMaxAge time.Duration = 365 * 24 * time.Hour
+
+//ErrMissingCertificate è l'errore restituito quando non sono indicati i file del certificato e della chiave.
+var ErrMissingCertificate error = errors.New("missing certificate or key file")
+
+//DefaultTLSHosts restituisce i nomi e gli indirizzi di default dei certificati autofirmati per l'uso in locale.
+func DefaultTLSHosts() []string {
+	return []string{"localhost", "127.0.0.1", "::1"}
+}
+
+/*
+TLSOptions contiene le impostazioni del metodo Lifecycle.RunTLS.
+
+  CertFile        file PEM del certificato, con gli eventuali certificati intermedi
+  KeyFile         file PEM della chiave privata
+  SelfSigned      se true e i due file non esistono, è creato un certificato autofirmato
+                  per l'uso in locale e salvato nei file, così è riutilizzato agli avvii successivi
+  Hosts           nomi e indirizzi IP del certificato autofirmato, DefaultTLSHosts() se nil
+  RedirectAddr    indirizzo di un server HTTP che reindirizza tutte le richieste a HTTPS,
+                  ad esempio ":80"; se vuoto il server non è avviato
+  ReloadInterval  intervallo minimo fra due verifiche dei file del certificato,
+                  DefaultCertReloadInterval se minore o uguale a zero
+*/
+type TLSOptions struct {
+	CertFile       string
+	KeyFile        string
+	SelfSigned     bool
+	Hosts          []string
+	RedirectAddr   string
+	ReloadInterval time.Duration
+}
+
+/*
+GenerateCertificate crea un certificato autofirmato con chiave ECDSA P-256, valido per DefaultCertValidity
+per i nomi e gli indirizzi IP specificati, e lo salva nei file PEM indicati.
+La chiave è scritta con i permessi 0600 e le cartelle mancanti sono create.
+
+Il certificato serve per l'uso in locale e per i test: il browser mostra un avviso
+finché non è aggiunto ai certificati fidati.
+*/
+func GenerateCertificate(certFile, keyFile string, hosts []string) error {
+	if len(hosts) == 0 {
+		hosts = DefaultTLSHosts()
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"webman"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(DefaultCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []string{certFile, keyFile} {
+		if err = os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			return err
+		}
+	}
+	// la chiave è scritta per prima: il certificato senza la sua chiave non sarebbe caricato
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// fileExists restituisce true se il file specificato esiste.
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// ===== Tipo CertReloader =====
+
+/*
+CertReloader carica un certificato dai file PEM e lo ricarica quando i file cambiano,
+così un certificato rinnovato è usato dalle nuove connessioni senza riavviare il server.
+
+I file sono verificati durante le connessioni TLS, al massimo una volta per intervallo.
+Se il nuovo certificato non può essere caricato, ad esempio perché è stato scritto
+solo uno dei due file, resta in uso quello precedente e la verifica è ripetuta all'intervallo successivo.
+
+  cr, err := webman.NewCertReloader("cert.pem", "key.pem", 0, nil)
+  server.TLSConfig = &tls.Config{GetCertificate: cr.GetCertificate}
+*/
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	log      *log.Logger
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	stamp    string
+	checked  time.Time
+}
+
+/*
+NewCertReloader restituisce un CertReloader con il certificato caricato dai file specificati,
+oppure l'errore del caricamento.
+
+Il parametro interval indica l'intervallo minimo fra due verifiche dei file;
+se è minore o uguale a zero è usato DefaultCertReloadInterval.
+
+Il log è scritto sull'oggetto log.Logger specificato. Se il parametro è nil, CertReloader scrive su os.Stderr.
+*/
+func NewCertReloader(certFile, keyFile string, interval time.Duration, logger *log.Logger) (*CertReloader, error) {
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+	if logger == nil {
+		logger = log.New(os.Stderr, "", (log.LstdFlags | log.LUTC))
+	}
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile, interval: interval, log: logger}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// fileStamp restituisce una stringa che cambia quando cambia uno dei due file.
+func (cr *CertReloader) fileStamp() (string, error) {
+	var sb strings.Builder
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(strconv.FormatInt(fi.ModTime().UnixNano(), 10) + ":" + strconv.FormatInt(fi.Size(), 10) + ";")
+	}
+	return sb.String(), nil
+}
+
+// load carica il certificato dai file; va chiamato con il mutex bloccato.
+func (cr *CertReloader) load() error {
+	stamp, err := cr.fileStamp()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert, cr.stamp, cr.checked = &cert, stamp, time.Now()
+	return nil
+}
+
+//Reload carica subito il certificato dai file. Se il caricamento non riesce, resta in uso il certificato precedente.
+func (cr *CertReloader) Reload() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.load()
+}
+
+//GetCertificate restituisce il certificato in uso, dopo averlo ricaricato se i file sono cambiati.
+//Va assegnato al campo GetCertificate di tls.Config.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if time.Since(cr.checked) < cr.interval {
+		return cr.cert, nil
+	}
+	cr.checked = time.Now()
+	if stamp, err := cr.fileStamp(); (err == nil) && (stamp == cr.stamp) {
+		return cr.cert, nil
+	}
+	if err := cr.load(); err != nil {
+		cr.log.Printf("CERTIFICATO NON RICARICATO: %s: %v\n", cr.certFile, err)
+	} else {
+		cr.log.Printf("CERTIFICATO RICARICATO: %s\n", cr.certFile)
+	}
+	return cr.cert, nil
+}
+
+/*
+RedirectHTTPS restituisce un gestore che reindirizza le richieste allo stesso percorso con HTTPS,
+con il codice 301 per GET e HEAD e 308 per gli altri metodi, così il corpo della richiesta è inviato di nuovo.
+
+Il parametro addr è l'indirizzo del server HTTPS, ad esempio ":443" o ":8443":
+la porta è aggiunta al nome dell'host della richiesta se diversa da 443.
+*/
+func RedirectHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			WriteStatus(http.StatusBadRequest, "Host mancante.", r.URL.Path, w)
+			return
+		}
+		if (port != "") && (port != "443") {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := http.StatusPermanentRedirect
+		if (r.Method == http.MethodGet) || (r.Method == http.MethodHead) {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+/*
+HSTSOptions contiene le impostazioni del middleware HSTS.
+
+  MaxAge             durata per cui il browser deve usare solo HTTPS, DefaultHSTSMaxAge se zero
+  IncludeSubdomains  se true la regola vale anche per i sottodomini
+  Preload            se true il sito può essere inserito negli elenchi HSTS dei browser
+*/
+type HSTSOptions struct {
+	MaxAge            time.Duration
+	IncludeSubdomains bool
+	Preload           bool
+}
+
+/*
+HSTS restituisce un middleware che aggiunge alle risposte delle richieste su TLS l'intestazione
+"Strict-Transport-Security", con cui il browser usa solo HTTPS per il sito per la durata indicata.
+Le risposte delle richieste senza TLS non sono modificate: i browser ignorerebbero l'intestazione.
+
+Una durata negativa invia max-age=0, che cancella la regola salvata dal browser.
+*/
+func HSTS(opt HSTSOptions) Middleware {
+	if opt.MaxAge == 0 {
+		opt.MaxAge = DefaultHSTSMaxAge
+	}
+	if opt.MaxAge < 0 {
+		opt.MaxAge = 0
+	}
+	value := "max-age=" + strconv.FormatInt(int64(opt.MaxAge/time.Second), 10)
+	if opt.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if opt.Preload {
+		value += "; preload"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tlsConfig prepara la configurazione TLS del server con le opzioni specificate,
+// creando il certificato autofirmato se richiesto.
+func (lc *Lifecycle) tlsConfig(opt TLSOptions) (*tls.Config, error) {
+	if (opt.CertFile == "") || (opt.KeyFile == "") {
+		return nil, ErrMissingCertificate
+	}
+	if opt.SelfSigned && !fileExists(opt.CertFile) && !fileExists(opt.KeyFile) {
+		if err := GenerateCertificate(opt.CertFile, opt.KeyFile, opt.Hosts); err != nil {
+			return nil, fmt.Errorf("certificato autofirmato: %w", err)
+		}
+		lc.log.Printf("CERTIFICATO AUTOFIRMATO CREATO: %s\n", opt.CertFile)
+	}
+	cr, err := NewCertReloader(opt.CertFile, opt.KeyFile, opt.ReloadInterval, lc.log)
+	if err != nil {
+		return nil, err
+	}
+	cfg := lc.server.TLSConfig.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.GetCertificate = cr.GetCertificate
+	return cfg, nil
+}
@@ -8,15 +8,25 @@ Package webman implementa tipi e funzioni per la gestione di un server web.
 package webman
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 )
 
+// ===== Tipo methodAction =====
+
+// methodAction contiene il gestore e il codice di stato associati a un metodo HTTP.
+type methodAction struct {
+	statusCode int
+	handler    http.Handler
+}
+
 // ===== Tipo serverAction =====
 
 type serverAction struct {
@@ -24,6 +34,8 @@ type serverAction struct {
 	statusReply bool
 	statusCode  int
 	handler     http.Handler
+	methods     map[string]methodAction
+	segments    []patternSegment
 }
 
 // isStatusReply restituisce true se l'oggetto serverAction gestisce il codice di stato specificato.
@@ -31,6 +43,43 @@ func (sa serverAction) isStatusReply(code int) bool {
 	return (sa.statusReply && sa.statusCode == code)
 }
 
+// methodAction restituisce il gestore per il metodo di richiesta specificato e true se il metodo è ammesso.
+//
+// Le richieste HEAD sono affidate al gestore del metodo GET se non ne è stato associato uno specifico.
+// Il gestore associato senza metodo risponde a tutti i metodi senza un gestore specifico.
+func (sa serverAction) methodAction(method string) (ma methodAction, ok bool) {
+	if ma, ok = sa.methods[method]; ok {
+		return
+	}
+	if method == http.MethodHead {
+		if ma, ok = sa.methods[http.MethodGet]; ok {
+			return
+		}
+	}
+	if sa.handler != nil || len(sa.methods) == 0 {
+		return methodAction{statusCode: sa.statusCode, handler: sa.handler}, true
+	}
+	return methodAction{}, false
+}
+
+// allowedMethods restituisce l'elenco ordinato dei metodi ammessi per il valore "Allow" dell'intestazione.
+func (sa serverAction) allowedMethods() string {
+	list := make([]string, 0, len(sa.methods)+2)
+	for method := range sa.methods {
+		list = append(list, method)
+	}
+	if _, ok := sa.methods[http.MethodGet]; ok {
+		if _, ok = sa.methods[http.MethodHead]; !ok {
+			list = append(list, http.MethodHead)
+		}
+	}
+	if _, ok := sa.methods[http.MethodOptions]; !ok {
+		list = append(list, http.MethodOptions)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
 // statusKey restituisce la chiave per i gestori di stato.
 func statusKey(code int) string {
 	return fmt.Sprintf("#STATUS_%d#", code)
@@ -49,11 +98,38 @@ associati gestori di richiesta per percorsi e per codici di stato HTTP.
 I gestori di codice sono associati con il metodo EnlistStatusReply, mentre con
 i metodi Enlist e EnlistFunc puoi associare un gestore a un percorso.
 
+Con i metodi EnlistMethod e EnlistMethodFunc puoi associare a uno stesso percorso
+gestori diversi per ogni metodo HTTP (ad esempio GET, POST e DELETE). Per questi percorsi:
+
+  le richieste HEAD sono affidate al gestore GET se non ne è stato associato uno specifico
+  le richieste OPTIONS ricevono la risposta 204 con l'elenco dei metodi ammessi nel valore "Allow"
+  le richieste con un metodo non ammesso ricevono la risposta 405 con il valore "Allow"
+
+Un gestore associato con Enlist allo stesso percorso risponde a tutti i metodi senza un gestore specifico.
+
 Un percorso che termina con lo slash indica che il relativo gestore può replicare a tutte le richieste il cui percorso ha quella stessa radice.
+
+Un percorso può contenere parametri che occupano un intero segmento:
+
+  /note/{id}          corrisponde a /note/3 ma non a /note/ o /note/3/modifica
+  /files/{path...}    corrisponde a /files/, /files/a e /files/a/b.txt
+
+I valori dei parametri sono restituiti dalle funzioni PathParam e PathParamInt64.
+Se a una richiesta corrispondono più percorsi, è scelto il percorso uguale alla richiesta,
+altrimenti quello con la parte fissa iniziale più lunga; fra percorsi con parametri
+è scelto quello più specifico (/note/nuova prima di /note/{id}).
+Due percorsi con parametri che corrispondono alle stesse richieste, o ad alcune stesse
+richieste senza che uno sia più specifico (/a/{x}/c e /a/b/{y}), sono in conflitto
+e l'associazione del secondo genera un panic con l'errore ErrPatternConflict.
+
+Con il metodo Use puoi aggiungere middleware applicati a tutte le richieste,
+mentre con la funzione Chain puoi avvolgere con middleware il gestore di un singolo percorso.
 */
 type ServerManager struct {
-	actions actionMap
-	log     *log.Logger
+	actions     actionMap
+	log         *log.Logger
+	middlewares []Middleware
+	chain       http.Handler
 }
 
 //ErrServerManagerNotReady è l'errore restituito quando il server manager non è stato inizializzato.
@@ -66,13 +142,15 @@ Nell'oggetto sono impostate le seguenti risposte di default:
 
   DefaultBadRequestReply per il codice http.StatusBadRequest (400)
   DefaultNotFoundReply per il codice http.StatusNotFound (404)
+  DefaultMethodNotAllowedReply per il codice http.StatusMethodNotAllowed (405)
   DefaultServerErrorReply per il codice http.StatusInternalServerError (500)
 
 per cambiare questi gestori di stato o aggiungere altri usa il metodo EnlistStatusReply.
 
-Per aggiungere o cambiare gestori di percorso usa i metodi Enlist e EnlistFunc.
+Per aggiungere o cambiare gestori di percorso usa i metodi Enlist, EnlistFunc, EnlistMethod e EnlistMethodFunc.
 
 Il log interno è scritto sull'oggetto log.Logger specificato. Se il parametro è nil, il gestore scrive su os.Stderr.
+Per scrivere nel log anche le richieste ricevute aggiungi il middleware AccessLog con il metodo Use.
 */
 func NewServerManager(logger *log.Logger) *ServerManager {
 	// crea l'oggetto
@@ -86,6 +164,7 @@ func NewServerManager(logger *log.Logger) *ServerManager {
 	// imposta i gestori di default
 	sm.EnlistStatusReply(http.StatusBadRequest, http.HandlerFunc(DefaultBadRequestReply))
 	sm.EnlistStatusReply(http.StatusNotFound, http.HandlerFunc(DefaultNotFoundReply))
+	sm.EnlistStatusReply(http.StatusMethodNotAllowed, http.HandlerFunc(DefaultMethodNotAllowedReply))
 	sm.EnlistStatusReply(http.StatusInternalServerError, http.HandlerFunc(DefaultServerErrorReply))
 	return sm
 }
@@ -98,19 +177,19 @@ func (sm *ServerManager) IsReady() bool {
 /*
 Enlist imposta il gestore per un determinato percorso di richiesta e con un codice di stato specificati.
 
-Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false
+e con gli errori ErrInvalidPattern o ErrPatternConflict se il percorso contiene parametri non validi o in conflitto.
 */
 func (sm *ServerManager) Enlist(path string, hler http.Handler, code int) {
 	if !sm.IsReady() {
 		panic(ErrServerManagerNotReady)
 	}
-	var pattern string
-	if path[0] == '/' {
-		pattern = path
-	} else {
-		pattern = "/" + path
-	}
-	sm.actions[pattern] = serverAction{path: pattern, statusReply: false, statusCode: code, handler: hler}
+	pattern := actionPath(path)
+	segs := sm.checkPattern(pattern)
+	// mantiene gli eventuali gestori associati ai metodi
+	a := sm.actions[pattern]
+	a.path, a.statusReply, a.statusCode, a.handler, a.segments = pattern, false, code, hler, segs
+	sm.actions[pattern] = a
 }
 
 /*
@@ -140,12 +219,66 @@ func (sm *ServerManager) EnlistFuncOK(path string, f http.HandlerFunc) {
 	sm.Enlist(path, f, http.StatusOK)
 }
 
+/*
+EnlistMethod imposta il gestore per un metodo HTTP e un percorso di richiesta con un codice di stato specificati.
+
+Allo stesso percorso possono essere associati gestori diversi per ogni metodo.
+Il metodo è convertito in maiuscolo.
+
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false
+e con gli errori ErrInvalidPattern o ErrPatternConflict se il percorso contiene parametri non validi o in conflitto.
+*/
+func (sm *ServerManager) EnlistMethod(method string, path string, hler http.Handler, code int) {
+	if !sm.IsReady() {
+		panic(ErrServerManagerNotReady)
+	}
+	pattern := actionPath(path)
+	segs := sm.checkPattern(pattern)
+	a, ok := sm.actions[pattern]
+	if !ok {
+		a = serverAction{path: pattern, statusReply: false, statusCode: http.StatusOK, segments: segs}
+	}
+	if a.methods == nil {
+		a.methods = make(map[string]methodAction, 2)
+	}
+	a.methods[strings.ToUpper(method)] = methodAction{statusCode: code, handler: hler}
+	sm.actions[pattern] = a
+}
+
+/*
+EnlistMethodOK imposta il gestore per un metodo HTTP e un percorso di richiesta specificati e con il codice di stato http.StatusOK.
+
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+*/
+func (sm *ServerManager) EnlistMethodOK(method string, path string, hler http.Handler) {
+	sm.EnlistMethod(method, path, hler, http.StatusOK)
+}
+
+/*
+EnlistMethodFunc imposta una funzione come gestore per un metodo HTTP e un percorso di richiesta con un codice di stato specificati.
+
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+*/
+func (sm *ServerManager) EnlistMethodFunc(method string, path string, f http.HandlerFunc, code int) {
+	sm.EnlistMethod(method, path, f, code)
+}
+
+/*
+EnlistMethodFuncOK imposta una funzione come gestore per un metodo HTTP e un percorso di richiesta specificati e con il codice di stato http.StatusOK.
+
+Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+*/
+func (sm *ServerManager) EnlistMethodFuncOK(method string, path string, f http.HandlerFunc) {
+	sm.EnlistMethod(method, path, f, http.StatusOK)
+}
+
 /*
 EnlistStatusReply imposta il gestore per un codice di stato.
 
 Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
 
 Con questo metodo puoi impostare ad esempio il gestore per il codice 404 (Pagina non trovata).
+Il gestore riceve i dettagli del problema inviati con ReplyProblem o ReplyStatus tramite la funzione RequestProblem.
 */
 func (sm *ServerManager) EnlistStatusReply(code int, hler http.Handler) {
 	if !sm.IsReady() {
@@ -158,29 +291,28 @@ func (sm *ServerManager) EnlistStatusReply(code int, hler http.Handler) {
 /*
 ReplyStatus risponde alla richiesta con il gestore associato al codice HTTP specificato.
 
-Il metodo genera un panic con l'errore ErrServerManagerNotReady se il metodo IsReady restituisce false.
+Il metodo equivale a ReplyProblem con il problema restituito da NewProblem(code, message):
+il gestore di stato può leggerlo con la funzione RequestProblem e, se nessun gestore è stato associato
+al codice specificato, la risposta è inviata dalla funzione WriteProblem nel formato preferito dalla richiesta.
+
+Se il metodo IsReady restituisce false, ReplyStatus risponde direttamente con la funzione WriteProblem.
 
-Un'annotazione contenente il codice HTTP della risposta è inserita all'interno del log interno.
+Un'annotazione contenente il codice HTTP della risposta e l'eventuale identificativo della richiesta
+è inserita all'interno del log interno.
 
-Se nessun gestore è stato associato al codice specificato, ReplyStatus affida la risposta
-alla funzione WriteStatus passando il codice e il messaggio specificati insieme al percorso della richiesta.
+Se l'intestazione della risposta è già stata inviata, ReplyStatus scrive solo l'annotazione nel log
+per non inviare una seconda intestazione. Il controllo è possibile per le richieste ricevute da ServeHTTP.
 */
 func (sm *ServerManager) ReplyStatus(code int, message string, w http.ResponseWriter, r *http.Request) {
-	if !sm.IsReady() {
-		panic(ErrServerManagerNotReady)
-	}
-	// scrive nel log lo stato e il messaggio
-	sm.log.Printf("RISPOSTA: STATO %d\n", code)
-	sm.log.Printf("MESSAGGIO: [%s]\n", message)
-	// trova l'azione con il gestore risposta stato
-	a := sm.actions[statusKey(code)]
-	if a.isStatusReply(code) && a.handler != nil {
-		// se c'è, lo usa
-		a.handler.ServeHTTP(w, r)
-	} else {
-		// se non c'è, risponde direttamente
-		WriteStatus(code, message, r.URL.Path, w)
+	sm.ReplyProblem(NewProblem(code, message), w, r)
+}
+
+// actionPath restituisce il percorso di richiesta con lo slash iniziale.
+func actionPath(path string) string {
+	if len(path) > 0 && path[0] == '/' {
+		return path
 	}
+	return "/" + path
 }
 
 // pathBegins restituisce true se path richiesta è uguale a path azione o ha la stessa radice.
@@ -198,8 +330,9 @@ func pathBegins(reqPath, actPath string) bool {
 	return ((len(reqPath) >= aLen) && (reqPath[:aLen] == actPure))
 }
 
-// getAction cerca l'azione per il percorso di richiesta specificato.
-func (sm *ServerManager) getAction(reqPath string) (a serverAction) {
+// getAction cerca l'azione per il percorso di richiesta specificato
+// e restituisce anche i valori degli eventuali parametri di percorso.
+func (sm *ServerManager) getAction(reqPath string) (a serverAction, params map[string]string) {
 	// imposta il ritorno su reply StatusNotFound
 	a = serverAction{path: "", statusReply: true, statusCode: http.StatusNotFound}
 	// esce se ServerManager non è stato inizializzato
@@ -207,12 +340,23 @@ func (sm *ServerManager) getAction(reqPath string) (a serverAction) {
 		return
 	}
 	// ricerca azione con path identica
-	if action, ok := sm.actions[reqPath]; ok {
-		return action
+	if action, ok := sm.actions[reqPath]; ok && action.segments == nil {
+		return action, nil
 	}
 	// ricerca azione con path identica a radice path richiesta
+	// e azione con parametri più specifica
 	var lastFoundLen = -1
+	var pattern *serverAction
 	for _, act := range sm.actions {
+		if act.segments != nil {
+			if p, ok := matchPattern(act.segments, reqPath); ok {
+				if pattern == nil || comparePatterns(act.segments, pattern.segments) == patternMoreSpecific {
+					act := act
+					pattern, params = &act, p
+				}
+			}
+			continue
+		}
 		if !pathBegins(reqPath, act.path) {
 			continue
 		}
@@ -221,7 +365,54 @@ func (sm *ServerManager) getAction(reqPath string) (a serverAction) {
 			a = act
 		}
 	}
-	return
+	// sceglie l'azione con la parte fissa iniziale più lunga
+	if pattern != nil && len(literalPrefix(pattern.path)) >= lastFoundLen {
+		return *pattern, params
+	}
+	return a, nil
+}
+
+// routeKey è la chiave del contesto che contiene l'azione trovata per il percorso della richiesta.
+type routeKey struct{}
+
+// requestRoute contiene l'azione trovata per un percorso di richiesta e i suoi parametri.
+type requestRoute struct {
+	path   string
+	action serverAction
+	params map[string]string
+}
+
+// withRoute cerca l'azione per il percorso della richiesta e restituisce la richiesta con l'azione nel contesto,
+// così i middleware e dispatch la leggono senza cercarla di nuovo.
+func (sm *ServerManager) withRoute(r *http.Request) *http.Request {
+	rt := &requestRoute{path: r.URL.Path}
+	rt.action, rt.params = sm.getAction(rt.path)
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, rt))
+}
+
+// route restituisce l'azione e i parametri per il percorso della richiesta letti dal contesto.
+// L'azione è cercata di nuovo se la richiesta non è stata ricevuta da ServeHTTP o se un middleware
+// ha cambiato il percorso; in questo caso il contesto è aggiornato con la nuova azione.
+func (sm *ServerManager) route(r *http.Request) (serverAction, map[string]string) {
+	rt, ok := r.Context().Value(routeKey{}).(*requestRoute)
+	if !ok {
+		return sm.getAction(r.URL.Path)
+	}
+	if rt.path != r.URL.Path {
+		rt.path = r.URL.Path
+		rt.action, rt.params = sm.getAction(rt.path)
+	}
+	return rt.action, rt.params
+}
+
+// servedAction restituisce l'azione usata da dispatch per rispondere alla richiesta, letta dal contesto
+// anche se un middleware successivo ha cambiato il percorso. Va chiamato dopo la risposta.
+func (sm *ServerManager) servedAction(r *http.Request) serverAction {
+	if rt, ok := r.Context().Value(routeKey{}).(*requestRoute); ok {
+		return rt.action
+	}
+	a, _ := sm.getAction(r.URL.Path)
+	return a
 }
 
 // -- Implementazione dell'interfaccia http.Handler --
@@ -229,25 +420,51 @@ func (sm *ServerManager) getAction(reqPath string) (a serverAction) {
 /*
 ServeHTTP smista le richieste dal server ai vari gestori associati a ServerManager.
 
-Il metodo scrive nel log interno i dettagli della richiesta e cerca il gestore a cui affidare la richiesta.
+Il metodo affida la richiesta agli eventuali middleware aggiunti con Use e poi cerca il gestore a cui affidarla.
+
+Se trova il gestore che può replicare al percorso e al metodo di richiesta, usa il relativo metodo ServeHTTP.
 
-Se trova il gestore che può replicare al percorso di richiesta, usa il relativo metodo ServeHTTP.
+Se al percorso sono associati gestori per metodo e nessuno corrisponde al metodo di richiesta,
+risponde alle richieste OPTIONS con il codice 204 e alle altre con ReplyStatus inviando il codice 405,
+in entrambi i casi con l'elenco dei metodi ammessi nel valore "Allow" dell'intestazione.
 
 Se il gestore associato a un percorso è nil, risponde con ReplyStatus inviando il codice HTTP associato al percorso.
 
 Se nessun gestore può replicare al percorso di richiesta, il metodo invia la risposta 404 (Pagina non trovata) con il gestore di stato associato al codice.
 Questo gestore corrisponde a DefaultNotFoundReply se non è stato sostituito con il metodo EnlistStatusReply.
+
+Se un gestore o un middleware genera un panic, il metodo lo recupera, scrive nel log il valore e lo stack
+con l'identificativo della richiesta e risponde con il gestore di stato associato al codice 500
+(DefaultServerErrorReply se non è stato sostituito). Se la risposta è già iniziata, non è inviata
+una seconda intestazione e la risposta resta incompleta. I panic dei gestori sono recuperati
+prima che la risposta torni ai middleware, che quindi ricevono il codice 500.
 */
 func (sm *ServerManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// scrive nel log i dettagli della richiesta
-	sm.log.Print("====================")
-	sm.log.Printf("host: %s\n", r.Host)
-	sm.log.Printf("rURI: %s\n", r.RequestURI)
-	for k, v := range r.Header {
-		sm.log.Printf(" %s = %s\n", k, v)
+	if !sm.IsReady() {
+		sm.ReplyStatus(http.StatusNotFound, "", w, r)
+		return
+	}
+	// cerca una sola volta l'azione per il percorso di richiesta
+	r = sm.withRoute(r)
+	// registra l'inizio della risposta e recupera i panic dei middleware
+	rw := newResponseWriter(w)
+	defer sm.recoverPanic(rw, r)
+	if sm.chain != nil {
+		// esegue i middleware che terminano con dispatch
+		sm.chain.ServeHTTP(rw, r)
+		return
 	}
+	sm.safeDispatch(rw, r)
+}
+
+// dispatch cerca il gestore associato al percorso e al metodo di richiesta e gli affida la richiesta.
+func (sm *ServerManager) dispatch(w http.ResponseWriter, r *http.Request) {
 	// recupera l'azione associata al percorso di richiesta
-	action := sm.getAction(r.URL.Path)
+	action, params := sm.route(r)
+	if params != nil {
+		// rende disponibili i parametri di percorso ai gestori
+		r = withPathParams(r, params)
+	}
 	// azione risposta di stato
 	if action.statusReply {
 		// l'azione rappresenta una risposta di stato
@@ -256,13 +473,25 @@ func (sm *ServerManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// altrimenti azione associata ad un percorso
-	if action.handler != nil {
+	// cerca il gestore per il metodo di richiesta
+	ma, ok := action.methodAction(r.Method)
+	if !ok {
+		// metodo non ammesso
+		w.Header().Set("Allow", action.allowedMethods())
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			sm.ReplyStatus(http.StatusMethodNotAllowed, "", w, r)
+		}
+		return
+	}
+	if ma.handler != nil {
 		// gestore disponibile, lo usa
-		action.handler.ServeHTTP(w, r)
+		ma.handler.ServeHTTP(w, r)
 	} else {
 		// gestore non disponibile
 		// risponde con il codice di stato dell'azione
-		sm.ReplyStatus(action.statusCode, "", w, r)
+		sm.ReplyStatus(ma.statusCode, "", w, r)
 	}
 }
 
@@ -287,39 +516,59 @@ func WriteStatus(code int, message string, path string, w http.ResponseWriter) {
 }
 
 /*
-DefaultBadRequestReply invia la risposta di default per il codice 400.
+DefaultBadRequestReply invia la risposta di default per il codice 400 con la funzione WriteProblem.
+
+La descrizione è quella del problema ricevuto dal gestore con RequestProblem, se presente, altrimenti:
 
-  400 - Richiesta non valida. - Path: [percorso di richiesta]
+  Richiesta non valida.
 
 Questo metodo è usato da ServerManager per default. Per sostituirlo, imposta un gestore
 per questo codice con il metodo EnlistStatusReply.
 */
 func DefaultBadRequestReply(w http.ResponseWriter, r *http.Request) {
-	WriteStatus(http.StatusBadRequest, "Richiesta non valida.", r.URL.Path, w)
+	writeDefaultProblem(http.StatusBadRequest, "Richiesta non valida.", w, r)
 }
 
 /*
-DefaultNotFoundReply invia la risposta di default per il codice 404.
+DefaultNotFoundReply invia la risposta di default per il codice 404 con la funzione WriteProblem.
+
+La descrizione è quella del problema ricevuto dal gestore con RequestProblem, se presente, altrimenti:
 
-  404 - Pagina non trovata. - Path: [percorso di richiesta]
+  Pagina non trovata.
 
 Questo metodo è usato da ServerManager per default. Per sostituirlo, imposta un gestore
 per questo codice con il metodo EnlistStatusReply.
 */
 func DefaultNotFoundReply(w http.ResponseWriter, r *http.Request) {
-	WriteStatus(http.StatusNotFound, "Pagina non trovata.", r.URL.Path, w)
+	writeDefaultProblem(http.StatusNotFound, "Pagina non trovata.", w, r)
 }
 
 /*
-DefaultServerErrorReply invia la risposta di default per il codice 500.
+DefaultMethodNotAllowedReply invia la risposta di default per il codice 405 con la funzione WriteProblem.
 
-  500 - Errore interno del server. - Path: [percorso di richiesta]
+La descrizione è quella del problema ricevuto dal gestore con RequestProblem, se presente, altrimenti:
+
+  Metodo [metodo di richiesta] non consentito.
+
+Questo metodo è usato da ServerManager per default. Per sostituirlo, imposta un gestore
+per questo codice con il metodo EnlistStatusReply.
+*/
+func DefaultMethodNotAllowedReply(w http.ResponseWriter, r *http.Request) {
+	writeDefaultProblem(http.StatusMethodNotAllowed, fmt.Sprintf("Metodo %s non consentito.", r.Method), w, r)
+}
+
+/*
+DefaultServerErrorReply invia la risposta di default per il codice 500 con la funzione WriteProblem.
+
+La descrizione è quella del problema ricevuto dal gestore con RequestProblem, se presente, altrimenti:
+
+  Errore interno del server.
 
 Questo metodo è usato da ServerManager per default. Per sostituirlo, imposta un gestore
 per questo codice con il metodo EnlistStatusReply.
 */
 func DefaultServerErrorReply(w http.ResponseWriter, r *http.Request) {
-	WriteStatus(http.StatusInternalServerError, "Errore interno del server.", r.URL.Path, w)
+	writeDefaultProblem(http.StatusInternalServerError, "Errore interno del server.", w, r)
 }
 
 /*
@@ -347,16 +596,14 @@ func CheckMethod(r *http.Request, allowedMethods []string, replyNotAllowed bool,
 }
 
 /*
-CheckAccept verifica se la richiesta accetta uno dei mimetype specificati.
+CheckAccept verifica se la richiesta accetta uno dei mimetype specificati secondo le regole della funzione Negotiate,
+quindi considerando i pesi q e i tipi generici come application/*.
 
 Il parametro replyNotAcceptable indica se replicare con 406 NotAcceptable quando la richiesta non accetta nessuno dei mimetype.
 */
 func CheckAccept(r *http.Request, allowedMime []string, replyNotAcceptable bool, w http.ResponseWriter) bool {
-	accepted := r.Header.Get("Accept")
-	for _, mime := range allowedMime {
-		if strings.Contains(accepted, mime) {
-			return true
-		}
+	if Negotiate(r, allowedMime...) != "" {
+		return true
 	}
 
 	if replyNotAcceptable {
@@ -373,6 +620,7 @@ func CheckAccept(r *http.Request, allowedMime []string, replyNotAcceptable bool,
 /*
 ServeJSON risponde alla richiesta specificata con i dati nel parametro reply e il codice di stato specificato.
 
+Se la richiesta non accetta il tipo application/json, la risposta è 406 NotAcceptable.
 Nell'intestazione della risposta, il valore "Content-Type" è impostato su "application/json; charset=utf-8".
 
 Il contenuto di reply è scritto nella risposta direttamente se di tipo []byte o string,
@@ -380,7 +628,7 @@ altrimenti è prima serializzato in formato JSON con la funzione json.Marshal.
 La risposta sarà vuota se la funzione json.Marshal restituisce un errore.
 */
 func ServeJSON(r *http.Request, reply interface{}, code int, w http.ResponseWriter) {
-	if !CheckAccept(r, []string{"*/*", "application/*", "application/json"}, true, w) {
+	if !CheckAccept(r, []string{"application/json"}, true, w) {
 		return
 	}
 
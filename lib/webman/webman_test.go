@@ -0,0 +1,1436 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"container/list"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// rispondi restituisce un gestore che risponde con il testo specificato.
+func rispondi(testo string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testo)
+	}
+}
+
+// nuovoGestoreProva restituisce un ServerManager con il log disattivato.
+func nuovoGestoreProva() *ServerManager {
+	return NewServerManager(log.New(io.Discard, "", 0))
+}
+
+type datiRichiesta struct {
+	metodo string
+	path   string
+	codice int
+	corpo  string
+	allow  string
+}
+
+func verificaRichieste(t *testing.T, sm *ServerManager, dati []datiRichiesta) {
+	for _, d := range dati {
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, httptest.NewRequest(d.metodo, d.path, nil))
+
+		switch {
+		case w.Code != d.codice:
+			t.Errorf("ERR : La richiesta %s %s risponde con il codice %d invece di %d \n", d.metodo, d.path, w.Code, d.codice)
+
+		case d.corpo != "" && w.Body.String() != d.corpo:
+			t.Errorf("ERR : La richiesta %s %s risponde con %q invece di %q \n", d.metodo, d.path, w.Body.String(), d.corpo)
+
+		case w.Header().Get("Allow") != d.allow:
+			t.Errorf("ERR : La richiesta %s %s risponde con Allow %q invece di %q \n", d.metodo, d.path, w.Header().Get("Allow"), d.allow)
+
+		default:
+			t.Logf("MSG : La richiesta %s %s risponde con il codice %d \n", d.metodo, d.path, w.Code)
+		}
+	}
+}
+
+func TestMetodi(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note", rispondi("elenco"))
+	sm.EnlistMethodFuncOK("post", "/note", rispondi("aggiunta"))
+	sm.EnlistMethodFuncOK(http.MethodDelete, "/note", rispondi("rimossa"))
+	sm.EnlistFuncOK("/tutti", rispondi("tutti"))
+	sm.EnlistMethodFuncOK(http.MethodPut, "/tutti", rispondi("put"))
+	sm.EnlistMethodFuncOK(http.MethodPost, "/file/", rispondi("file"))
+
+	verificaRichieste(t, sm, []datiRichiesta{
+		{http.MethodGet, "/note", http.StatusOK, "elenco", ""},
+		{http.MethodPost, "/note", http.StatusOK, "aggiunta", ""},
+		{http.MethodDelete, "/note", http.StatusOK, "rimossa", ""},
+		{http.MethodHead, "/note", http.StatusOK, "", ""},
+		{http.MethodOptions, "/note", http.StatusNoContent, "", "DELETE, GET, HEAD, OPTIONS, POST"},
+		{http.MethodPut, "/note", http.StatusMethodNotAllowed, "", "DELETE, GET, HEAD, OPTIONS, POST"},
+		{http.MethodGet, "/tutti", http.StatusOK, "tutti", ""},
+		{http.MethodPut, "/tutti", http.StatusOK, "put", ""},
+		{http.MethodPost, "/file/a/b", http.StatusOK, "file", ""},
+		{http.MethodGet, "/file/a/b", http.StatusMethodNotAllowed, "", "OPTIONS, POST"},
+		{http.MethodGet, "/altro", http.StatusNotFound, "", ""},
+	})
+}
+
+// rispondiParametro restituisce un gestore che risponde con il valore del parametro di percorso specificato.
+func rispondiParametro(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+"="+PathParam(r, name))
+	}
+}
+
+func TestParametri(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.EnlistFuncOK("/", rispondi("home"))
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := PathParamInt64(r, "id")
+		if err != nil {
+			sm.ReplyStatus(http.StatusBadRequest, err.Error(), w, r)
+			return
+		}
+		io.WriteString(w, "nota "+strconv.FormatInt(id, 10))
+	})
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note/nuova", rispondi("nuova"))
+	sm.EnlistFuncOK("/note/{id}/legami/{altra}", rispondiParametro("altra"))
+	sm.EnlistFuncOK("/files/{path...}", rispondiParametro("path"))
+	sm.EnlistFuncOK("/files/img/", rispondi("immagini"))
+
+	verificaRichieste(t, sm, []datiRichiesta{
+		{http.MethodGet, "/note/3", http.StatusOK, "nota 3", ""},
+		{http.MethodGet, "/note/x", http.StatusBadRequest, "", ""},
+		{http.MethodGet, "/note/nuova", http.StatusOK, "nuova", ""},
+		{http.MethodPost, "/note/3", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/note/3/legami/7", http.StatusOK, "altra=7", ""},
+		{http.MethodGet, "/note/", http.StatusOK, "home", ""},
+		{http.MethodGet, "/files/", http.StatusOK, "path=", ""},
+		{http.MethodGet, "/files/a/b.txt", http.StatusOK, "path=a/b.txt", ""},
+		{http.MethodGet, "/files/img/logo.png", http.StatusOK, "immagini", ""},
+		{http.MethodGet, "/altro", http.StatusOK, "home", ""},
+	})
+}
+
+type datiConflitto struct {
+	primo     string
+	secondo   string
+	conflitto bool
+}
+
+var dtConflitti = []datiConflitto{
+	{"/note/{id}", "/note/{nid}", true},
+	{"/note/{id}", "/note/nuova", false},
+	{"/a/{x}/c", "/a/b/{y}", true},
+	{"/a/{x}/c", "/a/{y}/d", false},
+	{"/files/{path...}", "/files/{p}", false},
+	{"/files/{path...}", "/{dir}/{path...}", false},
+	{"/{dir}/img", "/files/{path...}", true},
+	{"/note/{id}", "/note/{id}/modifica", false},
+}
+
+func TestConflitti(t *testing.T) {
+	for _, dc := range dtConflitti {
+		errore := func() (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err, _ = p.(error)
+				}
+			}()
+			sm := nuovoGestoreProva()
+			sm.EnlistFuncOK(dc.primo, rispondi(""))
+			sm.EnlistFuncOK(dc.secondo, rispondi(""))
+			return
+		}()
+
+		switch {
+		case dc.conflitto && !errors.Is(errore, ErrPatternConflict):
+			t.Errorf("ERR : I percorsi %s e %s dovrebbero essere in conflitto \n", dc.primo, dc.secondo)
+
+		case !dc.conflitto && errore != nil:
+			t.Errorf("ERR : I percorsi %s e %s generano l'errore '%v' quando non dovrebbero \n", dc.primo, dc.secondo, errore)
+
+		default:
+			t.Logf("MSG : Verifica dei percorsi %s e %s riuscita: %v \n", dc.primo, dc.secondo, errore)
+		}
+	}
+
+	for _, path := range []string{"/note/{}", "/note/{a...}/b", "/note/x{id}", "/{a}/{a}"} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidPattern) {
+					t.Errorf("ERR : Il percorso %s dovrebbe generare l'errore ErrInvalidPattern invece di '%v' \n", path, err)
+				}
+			}()
+			nuovoGestoreProva().EnlistFuncOK(path, rispondi(""))
+		}()
+	}
+}
+
+// traccia restituisce un middleware che aggiunge il nome specificato all'intestazione "Traccia" della risposta.
+func traccia(nome string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Traccia", nome)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.Use(traccia("a"), traccia("b"))
+	sm.Use(traccia("c"))
+	sm.EnlistOK("/note", Chain(rispondi("note"), traccia("d"), traccia("e")))
+	lenta := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	sm.EnlistOK("/lenta", Chain(lenta, Timeout(10*time.Millisecond, "scaduto")))
+
+	dati := []struct {
+		path    string
+		codice  int
+		traccia string
+	}{
+		{"/note", http.StatusOK, "a b c d e"},
+		{"/altro", http.StatusNotFound, "a b c"},
+		{"/lenta", http.StatusServiceUnavailable, "a b c"},
+	}
+
+	for _, d := range dati {
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, d.path, nil))
+		tr := strings.Join(w.Header().Values("Traccia"), " ")
+
+		switch {
+		case w.Code != d.codice:
+			t.Errorf("ERR : La richiesta %s risponde con il codice %d invece di %d \n", d.path, w.Code, d.codice)
+
+		case tr != d.traccia:
+			t.Errorf("ERR : La richiesta %s attraversa i middleware %q invece di %q \n", d.path, tr, d.traccia)
+
+		default:
+			t.Logf("MSG : La richiesta %s attraversa i middleware %q \n", d.path, tr)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var strutturato, combinato bytes.Buffer
+	sm := nuovoGestoreProva()
+	sm.Use(AccessLog(AccessLogOptions{Logger: slog.New(slog.NewJSONHandler(&strutturato, nil)), LogHeaders: true}))
+	sm.Use(AccessLog(AccessLogOptions{Format: LogCombined, Writer: &combinato}))
+	sm.EnlistFuncOK("/note", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, RequestID(r))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/note?x=1", nil)
+	req.Header.Set(RequestIDHeader, "prova-1")
+	req.Header.Set("Authorization", "Bearer segreto")
+	req.Header.Set("User-Agent", "prova")
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, req)
+
+	if w.Body.String() != "prova-1" || w.Header().Get(RequestIDHeader) != "prova-1" {
+		t.Errorf("ERR : L'identificativo della richiesta è %q [%q] invece di %q \n", w.Body.String(), w.Header().Get(RequestIDHeader), "prova-1")
+	}
+
+	var voce struct {
+		RequestID string            `json:"request_id"`
+		Status    int               `json:"status"`
+		Bytes     int               `json:"bytes"`
+		Headers   map[string]string `json:"headers"`
+	}
+	switch err := json.Unmarshal(strutturato.Bytes(), &voce); {
+	case err != nil:
+		t.Errorf("ERR : La voce del log %q non è valida: %v \n", strutturato.String(), err)
+	case voce.RequestID != "prova-1" || voce.Status != http.StatusOK || voce.Bytes != 7:
+		t.Errorf("ERR : La voce del log %q non contiene identificativo, stato e byte della risposta \n", strutturato.String())
+	case voce.Headers["Authorization"] != RedactedValue || voce.Headers["User-Agent"] != "prova":
+		t.Errorf("ERR : Le intestazioni nel log %v non sono oscurate correttamente \n", voce.Headers)
+	default:
+		t.Logf("MSG : Voce del log strutturata: %s", strutturato.String())
+	}
+
+	attesa := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /note\?x=1 HTTP/1\.1" 200 7 "-" "prova"\n$`)
+	if !attesa.MatchString(combinato.String()) {
+		t.Errorf("ERR : La riga del log %q non è nel Combined Log Format \n", combinato.String())
+	}
+
+	// un identificativo non valido è sostituito
+	req = httptest.NewRequest(http.MethodGet, "/altro", nil)
+	req.Header.Set(RequestIDHeader, "non valido")
+	w = httptest.NewRecorder()
+	sm.ServeHTTP(w, req)
+	if id := w.Header().Get(RequestIDHeader); len(id) != 32 {
+		t.Errorf("ERR : L'identificativo generato %q non è valido \n", id)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var registro bytes.Buffer
+	sm := NewServerManager(log.New(&registro, "", 0))
+	sm.Use(AccessLog(AccessLogOptions{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}))
+	sm.EnlistStatusReply(http.StatusInternalServerError, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "errore")
+	}))
+	sm.EnlistFuncOK("/panico", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		panic("guasto")
+	})
+	sm.EnlistFuncOK("/iniziata", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "parziale")
+		panic("guasto")
+	})
+	sm.EnlistFuncOK("/pagina", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pagina")
+		sm.ReplyStatus(http.StatusInternalServerError, "template non valido", w, r)
+	})
+
+	verificaRichieste(t, sm, []datiRichiesta{
+		{http.MethodGet, "/panico", http.StatusInternalServerError, "errore", ""},
+		{http.MethodGet, "/iniziata", http.StatusOK, "parziale", ""},
+		{http.MethodGet, "/pagina", http.StatusOK, "pagina", ""},
+	})
+
+	if !strings.Contains(registro.String(), "PANIC [") || !strings.Contains(registro.String(), "goroutine") {
+		t.Errorf("ERR : Il log non contiene il panic con lo stack: %q \n", registro.String())
+	}
+
+	// ReplyStatus non genera un panic se il gestore non è pronto
+	w := httptest.NewRecorder()
+	new(ServerManager).ReplyStatus(http.StatusTeapot, "teiera", w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("ERR : ReplyStatus di un gestore non pronto risponde con il codice %d invece di %d \n", w.Code, http.StatusTeapot)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ERR : Apertura della porta non riuscita: %v \n", err)
+	}
+
+	iniziata := make(chan struct{})
+	sm := nuovoGestoreProva()
+	sm.EnlistFuncOK("/lenta", func(w http.ResponseWriter, r *http.Request) {
+		close(iniziata)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "completata")
+	})
+
+	server := &http.Server{Handler: sm}
+	lc := NewLifecycle(server, time.Second, log.New(io.Discard, "", 0))
+	var ordine []string
+	lc.BeforeShutdown("prima", func(ctx context.Context) error {
+		ordine = append(ordine, "prima")
+		return nil
+	})
+	lc.OnShutdown("primo", func(ctx context.Context) error {
+		ordine = append(ordine, "primo")
+		return nil
+	})
+	lc.OnShutdown("secondo", func(ctx context.Context) error {
+		ordine = append(ordine, "secondo")
+		return errors.New("chiusura non riuscita")
+	})
+
+	finito := make(chan error, 1)
+	go func() {
+		finito <- lc.run(func() error { return server.Serve(ln) }, nil)
+	}()
+
+	risposta := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String() + "/lenta")
+		if err != nil {
+			risposta <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		corpo, _ := io.ReadAll(res.Body)
+		risposta <- string(corpo)
+	}()
+
+	<-iniziata
+	lc.Stop()
+	lc.Stop()
+
+	if r := <-risposta; r != "completata" {
+		t.Errorf("ERR : La richiesta in corso alla chiusura riceve %q invece di %q \n", r, "completata")
+	}
+	err = <-finito
+	switch {
+	case err == nil || err.Error() != "chiusura non riuscita":
+		t.Errorf("ERR : Run restituisce '%v' invece dell'errore della funzione di chiusura \n", err)
+	case strings.Join(ordine, " ") != "prima primo secondo":
+		t.Errorf("ERR : Le funzioni di chiusura sono eseguite nell'ordine %v \n", ordine)
+	default:
+		t.Logf("MSG : Chiusura completata con le funzioni %v \n", ordine)
+	}
+
+	if err = lc.Run(); err != ErrLifecycleStarted {
+		t.Errorf("ERR : Il secondo avvio restituisce '%v' invece di ErrLifecycleStarted \n", err)
+	}
+}
+
+type datiNegoziazione struct {
+	accept  string
+	offerte []string
+	atteso  string
+}
+
+var dtNegoziazione = []datiNegoziazione{
+	{"", []string{"text/html", "application/json"}, "text/html"},
+	{"application/json", []string{"text/html", "application/json"}, "application/json"},
+	{"application/jsonp", []string{"application/json"}, ""},
+	{"application/json;q=0", []string{"application/json"}, ""},
+	{"*/*", []string{"text/html", "application/json"}, "text/html"},
+	{"application/*, */*;q=0.1", []string{"text/html", "application/json"}, "application/json"},
+	{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", []string{"application/json", "text/html"}, "text/html"},
+	{"text/html, */*", []string{"application/json", "text/html"}, "text/html"},
+	{"text/*;q=0.3, text/html;q=0.7, text/html;level=1, */*;q=0.5", []string{"text/plain", "text/html"}, "text/html"},
+	{"text/*;q=0.3, text/html;level=1;q=0.2, */*;q=0.5", []string{"text/html;level=1", "text/plain"}, "text/plain"},
+	{"text/*, text/plain;q=0", []string{"text/plain", "text/csv"}, "text/csv"},
+	{"APPLICATION/JSON; charset=utf-8", []string{"application/json; charset=UTF-8"}, "application/json; charset=UTF-8"},
+	{"application/json;q=2, */ *", []string{"application/json"}, ""},
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, dn := range dtNegoziazione {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if dn.accept != "" {
+			r.Header.Set("Accept", dn.accept)
+		}
+
+		if scelto := Negotiate(r, dn.offerte...); scelto != dn.atteso {
+			t.Errorf("ERR : Con Accept %q e offerte %v è scelto %q invece di %q \n", dn.accept, dn.offerte, scelto, dn.atteso)
+		} else {
+			t.Logf("MSG : Con Accept %q e offerte %v è scelto %q \n", dn.accept, dn.offerte, scelto)
+		}
+	}
+}
+
+type elementoProva struct {
+	ID      int    `json:"id" xml:"id,attr" csv:"codice"`
+	Nome    string `json:"nome" xml:"nome"`
+	Nota    string `json:"-" xml:"-" csv:"-"`
+	privato int
+}
+
+func (e elementoProva) String() string {
+	return strconv.Itoa(e.ID) + " " + e.Nome
+}
+
+type datiCodifica struct {
+	accept string
+	codice int
+	tipo   string
+	corpo  string
+}
+
+var dtCodifica = []datiCodifica{
+	{"", http.StatusOK, "application/json; charset=utf-8", `[{"id":1,"nome":"uno"},{"id":2,"nome":"a,\"b\""}]` + "\n"},
+	{"application/xml", http.StatusOK, "application/xml; charset=utf-8", xmlHeaderProva + `<elenco><elementoProva id="1"><nome>uno</nome></elementoProva><elementoProva id="2"><nome>a,&#34;b&#34;</nome></elementoProva></elenco>`},
+	{"text/csv", http.StatusOK, "text/csv; charset=utf-8", "codice,Nome\n1,uno\n2,\"a,\"\"b\"\"\"\n"},
+	{"application/x-ndjson", http.StatusOK, "application/x-ndjson", `{"id":1,"nome":"uno"}` + "\n" + `{"id":2,"nome":"a,\"b\""}` + "\n"},
+	{"text/plain", http.StatusOK, "text/plain; charset=utf-8", "1 uno\n2 a,\"b\"\n"},
+	{"image/png", http.StatusNotAcceptable, "", ""},
+}
+
+const xmlHeaderProva = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+func TestServe(t *testing.T) {
+	elenco := []elementoProva{{1, "uno", "x", 0}, {2, `a,"b"`, "y", 0}}
+	flusso := Stream(func(yield func(item any) bool) {
+		for _, e := range elenco {
+			if !yield(e) {
+				return
+			}
+		}
+	})
+
+	for _, valore := range []any{elenco, flusso} {
+		for _, dc := range dtCodifica {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if dc.accept != "" {
+				r.Header.Set("Accept", dc.accept)
+			}
+			w := httptest.NewRecorder()
+			if err := Serve(r, valore, http.StatusOK, w); err != nil {
+				t.Errorf("ERR : Con Accept %q errore %v \n", dc.accept, err)
+				continue
+			}
+
+			switch {
+			case w.Code != dc.codice:
+				t.Errorf("ERR : Con Accept %q il codice è %d invece di %d \n", dc.accept, w.Code, dc.codice)
+			case dc.codice != http.StatusOK:
+				t.Logf("MSG : Con Accept %q il codice è %d \n", dc.accept, w.Code)
+			case w.Header().Get("Content-Type") != dc.tipo:
+				t.Errorf("ERR : Con Accept %q il tipo è %q invece di %q \n", dc.accept, w.Header().Get("Content-Type"), dc.tipo)
+			case w.Body.String() != dc.corpo:
+				t.Errorf("ERR : Con Accept %q il corpo è %q invece di %q \n", dc.accept, w.Body.String(), dc.corpo)
+			default:
+				t.Logf("MSG : Con Accept %q risposta %q \n", dc.accept, w.Body.String())
+			}
+		}
+	}
+
+	// errore di codifica di un valore non in flusso: risposta 500
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	if err := Serve(r, func() {}, http.StatusOK, w, JSONEncoder{}); (err == nil) || (w.Code != http.StatusInternalServerError) {
+		t.Errorf("ERR : Codifica non valida: errore %v e codice %d \n", err, w.Code)
+	} else {
+		t.Logf("MSG : Codifica non valida: errore %v e codice %d \n", err, w.Code)
+	}
+}
+
+type notaProva struct {
+	ID    int64    `json:"id" form:"id" validate:"required,min=1"`
+	Testo string   `json:"nota" form:"nota" validate:"required,max=10"`
+	Corpo *string  `json:"corpo" form:"corpo"`
+	Fatto bool     `json:"fatto" form:"fatto"`
+	Stato string   `json:"stato" form:"stato" validate:"oneof=aperta chiusa"`
+	Tag   []string `json:"tag" form:"tag" validate:"max=2"`
+}
+
+type datiDecodifica struct {
+	tipo   string
+	corpo  string
+	codice int
+	errore error
+}
+
+var dtDecodificaJSON = []datiDecodifica{
+	{"application/json", `{"id":1,"nota":"prova","stato":"aperta"}`, http.StatusOK, nil},
+	{"application/problem+json; charset=utf-8", `{"id":1,"nota":"prova","stato":"chiusa","tag":["a"]}`, http.StatusOK, nil},
+	{"", `{"id":1,"nota":"prova","stato":"aperta"}`, http.StatusUnsupportedMediaType, ErrUnsupportedMediaType},
+	{"text/plain", `{"id":1,"nota":"prova","stato":"aperta"}`, http.StatusUnsupportedMediaType, ErrUnsupportedMediaType},
+	{"application/json", `{"id":1,"nota":"` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge, ErrBodyTooLarge},
+	{"application/json", ``, http.StatusBadRequest, ErrInvalidBody},
+	{"application/json", `{"id":1,`, http.StatusBadRequest, ErrInvalidBody},
+	{"application/json", `{"id":1,"nota":"prova","stato":"aperta","altro":2}`, http.StatusBadRequest, ErrInvalidBody},
+	{"application/json", `{"id":1,"nota":"prova","stato":"aperta"} {}`, http.StatusBadRequest, ErrInvalidBody},
+	{"application/json", `{"id":"1","nota":"prova","stato":"aperta"}`, http.StatusBadRequest, ErrInvalidBody},
+	{"application/json", `{"id":0,"nota":"  ","stato":"altro","tag":["a","b","c"]}`, http.StatusBadRequest, ValidationErrors{}},
+}
+
+var dtDecodificaForm = []datiDecodifica{
+	{"application/x-www-form-urlencoded", `id=1&nota=prova&stato=aperta&fatto=on&corpo=`, http.StatusOK, nil},
+	{"application/x-www-form-urlencoded; charset=utf-8", `id=2&nota=prova&stato=chiusa&tag=a&tag=b&fatto=false`, http.StatusOK, nil},
+	{"multipart/form-data; boundary=XX", "--XX\r\nContent-Disposition: form-data; name=\"id\"\r\n\r\n3\r\n--XX\r\nContent-Disposition: form-data; name=\"nota\"\r\n\r\nprova\r\n--XX\r\nContent-Disposition: form-data; name=\"stato\"\r\n\r\naperta\r\n--XX--\r\n", http.StatusOK, nil},
+	{"application/json", `id=1&nota=prova&stato=aperta`, http.StatusUnsupportedMediaType, ErrUnsupportedMediaType},
+	{"application/x-www-form-urlencoded", `id=1&nota=` + strings.Repeat("x", 300), http.StatusRequestEntityTooLarge, ErrBodyTooLarge},
+	{"application/x-www-form-urlencoded", `id=1&nota=prova&stato=aperta&altro=2`, http.StatusBadRequest, ErrInvalidBody},
+	{"application/x-www-form-urlencoded", `id=uno&nota=prova&stato=aperta`, http.StatusBadRequest, ValidationErrors{}},
+	{"application/x-www-form-urlencoded", `nota=prova+troppo+lunga&stato=aperta`, http.StatusBadRequest, ValidationErrors{}},
+}
+
+// verificaDecodifica esegue la funzione di decodifica per ogni richiesta e verifica errore e codice di stato.
+func verificaDecodifica(t *testing.T, nome string, decode func(*http.Request, any, int64, http.ResponseWriter) error, dati []datiDecodifica) {
+	for _, dd := range dati {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(dd.corpo))
+		if dd.tipo != "" {
+			r.Header.Set("Content-Type", dd.tipo)
+		}
+		w := httptest.NewRecorder()
+		var nt notaProva
+		err := decode(r, &nt, 250, w)
+
+		var ve ValidationErrors
+		switch {
+		case w.Code != dd.codice:
+			t.Errorf("ERR : %s %q: codice %d invece di %d - %v \n", nome, dd.corpo, w.Code, dd.codice, err)
+		case dd.errore == nil && err != nil:
+			t.Errorf("ERR : %s %q: errore %v \n", nome, dd.corpo, err)
+		case dd.errore == nil:
+			t.Logf("MSG : %s %q: decodificato %+v \n", nome, dd.corpo, nt)
+		case errors.As(dd.errore, &ve) && !errors.As(err, &ve):
+			t.Errorf("ERR : %s %q: errore %v invece di errori di convalida \n", nome, dd.corpo, err)
+		case !errors.As(dd.errore, &ve) && !errors.Is(err, dd.errore):
+			t.Errorf("ERR : %s %q: errore %v invece di %v \n", nome, dd.corpo, err, dd.errore)
+		default:
+			t.Logf("MSG : %s %q: codice %d - %v \n", nome, dd.corpo, w.Code, err)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	verificaDecodifica(t, "JSON", DecodeJSON, dtDecodificaJSON)
+
+	// ogni regola non soddisfatta produce un errore del campo
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id":0,"nota":"  ","stato":"altro","tag":["a","b","c"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	var nt notaProva
+	var ve ValidationErrors
+	if err := DecodeJSON(r, &nt, 0, nil); !errors.As(err, &ve) || len(ve) != 4 {
+		t.Errorf("ERR : Errori di convalida %v invece di 4 errori \n", err)
+	} else {
+		t.Logf("MSG : Errori di convalida %v \n", ve)
+	}
+}
+
+func TestDecodeForm(t *testing.T) {
+	verificaDecodifica(t, "Modulo", DecodeForm, dtDecodificaForm)
+
+	// valori della query per le richieste GET e campi puntatore impostati solo se presenti
+	var nt notaProva
+	r := httptest.NewRequest(http.MethodGet, "/?id=4&nota=prova&stato=aperta&corpo=testo", nil)
+	if err := DecodeForm(r, &nt, 0, nil); err != nil || nt.ID != 4 || nt.Corpo == nil || *nt.Corpo != "testo" {
+		t.Errorf("ERR : Query %q: errore %v, valore %+v \n", r.URL.RawQuery, err, nt)
+	} else {
+		t.Logf("MSG : Query %q: decodificato %+v \n", r.URL.RawQuery, nt)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Errorf("ERR : Nessun panic con una regola non valida \n")
+			} else if err, ok := p.(error); !ok || !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("ERR : Panic %v invece di ErrInvalidRule \n", p)
+			}
+		}()
+		var v struct {
+			Nome string `form:"nome" validate:"lunghezza=3"`
+		}
+		DecodeForm(httptest.NewRequest(http.MethodGet, "/?nome=abc", nil), &v, 0, nil)
+	}()
+}
+
+type datiProblema struct {
+	accept string
+	tipo   string
+	corpo  string
+}
+
+var dtProblemi = []datiProblema{
+	{"", "application/problem+json; charset=utf-8", `{"type":"about:blank","title":"Bad Request","status":400,"detail":"id non valido","instance":"/note/x","campi":["id"]}`},
+	{"application/json", "application/json; charset=utf-8", `{"type":"about:blank","title":"Bad Request","status":400,"detail":"id non valido","instance":"/note/x","campi":["id"]}`},
+	{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8", ""},
+	{"text/plain", "text/plain; charset=utf-8", "400 - id non valido - Path: /note/x"},
+	{"image/png", "text/plain; charset=utf-8", "400 - id non valido - Path: /note/x"},
+}
+
+func TestProblem(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.EnlistFuncOK("/note/", func(w http.ResponseWriter, r *http.Request) {
+		p := NewProblem(http.StatusBadRequest, "id non valido")
+		p.Extensions = map[string]any{"campi": []string{"id"}, "status": 0}
+		sm.ReplyProblem(p, w, r)
+	})
+
+	for _, dp := range dtProblemi {
+		r := httptest.NewRequest(http.MethodGet, "/note/x", nil)
+		if dp.accept != "" {
+			r.Header.Set("Accept", dp.accept)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+
+		switch {
+		case w.Code != http.StatusBadRequest:
+			t.Errorf("ERR : Con Accept %q il codice è %d invece di %d \n", dp.accept, w.Code, http.StatusBadRequest)
+		case w.Header().Get("Content-Type") != dp.tipo:
+			t.Errorf("ERR : Con Accept %q il tipo è %q invece di %q \n", dp.accept, w.Header().Get("Content-Type"), dp.tipo)
+		case dp.corpo != "" && w.Body.String() != dp.corpo:
+			t.Errorf("ERR : Con Accept %q il corpo è %q invece di %q \n", dp.accept, w.Body.String(), dp.corpo)
+		case dp.corpo == "" && !strings.Contains(w.Body.String(), "<p>id non valido</p>"):
+			t.Errorf("ERR : Con Accept %q la pagina non contiene la descrizione: %q \n", dp.accept, w.Body.String())
+		default:
+			t.Logf("MSG : Con Accept %q risposta %q \n", dp.accept, w.Body.String())
+		}
+	}
+
+	// il gestore di stato riceve il problema inviato con ReplyStatus
+	sm.EnlistStatusReply(http.StatusNotFound, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := RequestProblem(r)
+		if p == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(p.Status)
+		io.WriteString(w, p.Detail+" "+p.Instance)
+	}))
+	sm.EnlistFuncOK("/mancante", func(w http.ResponseWriter, r *http.Request) {
+		sm.ReplyStatus(http.StatusNotFound, "nota mancante", w, r)
+	})
+	verificaRichieste(t, sm, []datiRichiesta{
+		{http.MethodGet, "/mancante", http.StatusNotFound, "nota mancante /mancante", ""},
+		{http.MethodGet, "/altro", http.StatusNotFound, " /altro", ""},
+	})
+}
+
+type datiCondizione struct {
+	metodo       string
+	intestazione map[string]string
+	codice       int
+	descrizione  string
+}
+
+func TestCheckConditions(t *testing.T) {
+	etag := MakeETag("v2", false)
+	modifica := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	prima := modifica.Add(-time.Hour).Format(http.TimeFormat)
+	dopo := modifica.Add(time.Hour).Format(http.TimeFormat)
+
+	dati := []datiCondizione{
+		{http.MethodGet, nil, http.StatusOK, "nessuna condizione"},
+		{http.MethodGet, map[string]string{"If-None-Match": `"v1", "v2"`}, http.StatusNotModified, "If-None-Match corrisponde"},
+		{http.MethodGet, map[string]string{"If-None-Match": `W/"v2"`}, http.StatusNotModified, "If-None-Match con confronto debole"},
+		{http.MethodGet, map[string]string{"If-None-Match": `"v1"`}, http.StatusOK, "If-None-Match non corrisponde"},
+		{http.MethodGet, map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": dopo}, http.StatusOK, "If-None-Match prevale su If-Modified-Since"},
+		{http.MethodGet, map[string]string{"If-Modified-Since": dopo}, http.StatusNotModified, "non modificata dopo la data"},
+		{http.MethodGet, map[string]string{"If-Modified-Since": prima}, http.StatusOK, "modificata dopo la data"},
+		{http.MethodPost, map[string]string{"If-Match": `"v2"`}, http.StatusOK, "If-Match corrisponde"},
+		{http.MethodPost, map[string]string{"If-Match": "*"}, http.StatusOK, "If-Match qualunque"},
+		{http.MethodPost, map[string]string{"If-Match": `"v1"`}, http.StatusPreconditionFailed, "If-Match non corrisponde"},
+		{http.MethodPost, map[string]string{"If-Match": `W/"v2"`}, http.StatusPreconditionFailed, "If-Match con confronto forte"},
+		{http.MethodPost, map[string]string{"If-Unmodified-Since": prima}, http.StatusPreconditionFailed, "modificata dopo If-Unmodified-Since"},
+		{http.MethodPost, map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed, "If-None-Match su metodo non sicuro"},
+	}
+
+	for _, dc := range dati {
+		r := httptest.NewRequest(dc.metodo, "/nota", nil)
+		for k, v := range dc.intestazione {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		if CheckConditions(r, etag, modifica, w) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != dc.codice {
+			t.Errorf("ERR : %s %s: codice %d invece di %d \n", dc.metodo, dc.descrizione, w.Code, dc.codice)
+		} else {
+			t.Logf("MSG : %s %s: codice %d \n", dc.metodo, dc.descrizione, w.Code)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.Use(ETag(true))
+	sm.EnlistFuncOK("/pagina", rispondi("pagina"))
+	sm.EnlistFuncOK("/versione", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", MakeETag("7", false))
+		io.WriteString(w, "versione 7")
+	})
+	sm.EnlistFuncOK("/flusso", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "parte")
+		http.NewResponseController(w).Flush()
+	})
+
+	pagina := HashETag([]byte("pagina"), true)
+	dati := []struct {
+		path, inm string
+		codice    int
+		etag      string
+	}{
+		{"/pagina", "", http.StatusOK, pagina},
+		{"/pagina", pagina, http.StatusNotModified, pagina},
+		{"/pagina", `"altro"`, http.StatusOK, pagina},
+		{"/versione", `"7"`, http.StatusNotModified, `"7"`},
+		{"/flusso", "", http.StatusOK, ""},
+		{"/mancante", "", http.StatusNotFound, ""},
+	}
+	for _, d := range dati {
+		r := httptest.NewRequest(http.MethodGet, d.path, nil)
+		if d.inm != "" {
+			r.Header.Set("If-None-Match", d.inm)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		if (w.Code != d.codice) || (w.Header().Get("ETag") != d.etag) {
+			t.Errorf("ERR : %s [%s]: codice %d ETag %q invece di %d %q \n", d.path, d.inm, w.Code, w.Header().Get("ETag"), d.codice, d.etag)
+		} else {
+			t.Logf("MSG : %s [%s]: codice %d ETag %q \n", d.path, d.inm, w.Code, w.Header().Get("ETag"))
+		}
+		if (d.codice == http.StatusNotModified) && (w.Body.Len() > 0) {
+			t.Errorf("ERR : %s: la risposta 304 ha un corpo %q \n", d.path, w.Body.String())
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	testo := strings.Repeat("testo da comprimere ", 100)
+	sm := nuovoGestoreProva()
+	sm.Use(Compress(CompressOptions{}), ETag(false))
+	sm.EnlistFuncOK("/testo", rispondi(testo))
+	sm.EnlistFuncOK("/breve", rispondi("breve"))
+	sm.EnlistFuncOK("/immagine", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, testo)
+	})
+	sm.EnlistFuncOK("/codificato", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, testo)
+	})
+
+	dati := []struct {
+		path, ae string
+		codifica string
+	}{
+		{"/testo", "gzip, deflate", "gzip"},
+		{"/testo", "deflate", "deflate"},
+		{"/testo", "gzip;q=0.5, deflate", "deflate"},
+		{"/testo", "*", "gzip"},
+		{"/testo", "gzip;q=0, *;q=0", ""},
+		{"/testo", "identity", ""},
+		{"/testo", "", ""},
+		{"/breve", "gzip", ""},
+		{"/immagine", "gzip", ""},
+		{"/codificato", "gzip", "br"},
+	}
+	for _, d := range dati {
+		r := httptest.NewRequest(http.MethodGet, d.path, nil)
+		if d.ae != "" {
+			r.Header.Set("Accept-Encoding", d.ae)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		dimensione := w.Body.Len()
+
+		var corpo io.Reader = w.Body
+		var err error
+		switch w.Header().Get("Content-Encoding") {
+		case "gzip":
+			corpo, err = gzip.NewReader(w.Body)
+		case "deflate":
+			corpo, err = zlib.NewReader(w.Body)
+		}
+		var letto []byte
+		if err == nil {
+			letto, err = io.ReadAll(corpo)
+		}
+		switch {
+		case w.Header().Get("Content-Encoding") != d.codifica:
+			t.Errorf("ERR : %s [%s]: codifica %q invece di %q \n", d.path, d.ae, w.Header().Get("Content-Encoding"), d.codifica)
+		case w.Header().Get("Vary") != "Accept-Encoding":
+			t.Errorf("ERR : %s [%s]: Vary %q \n", d.path, d.ae, w.Header().Get("Vary"))
+		case err != nil:
+			t.Errorf("ERR : %s [%s]: %s \n", d.path, d.ae, err.Error())
+		case (d.path != "/breve") && (string(letto) != testo):
+			t.Errorf("ERR : %s [%s]: corpo diverso (%d byte) \n", d.path, d.ae, len(letto))
+		case (d.codifica == "gzip" || d.codifica == "deflate") && !strings.HasPrefix(w.Header().Get("ETag"), "W/"):
+			t.Errorf("ERR : %s [%s]: ETag forte %q nella risposta compressa \n", d.path, d.ae, w.Header().Get("ETag"))
+		default:
+			t.Logf("MSG : %s [%s]: codifica %q, %d byte \n", d.path, d.ae, w.Header().Get("Content-Encoding"), dimensione)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	sm := nuovoGestoreProva()
+	sm.Use(sm.CORS(CORSOptions{
+		AllowedOrigins: []string{"https://app.esempio.it", "http://localhost:*"},
+		ExposedHeaders: []string{"ETag"},
+		MaxAge:         10 * time.Minute}))
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note/{id}", rispondi("nota"))
+	sm.EnlistMethodFuncOK(http.MethodPut, "/note/{id}", rispondi("aggiornata"))
+
+	dati := []struct {
+		metodo, path, origine string
+		richiesto, intestazioni string
+		codice                  int
+		consentita              string
+		descrizione             string
+	}{
+		{http.MethodGet, "/note/1", "", "", "", http.StatusOK, "", "stessa origine"},
+		{http.MethodGet, "/note/1", "https://app.esempio.it", "", "", http.StatusOK, "https://app.esempio.it", "origine ammessa"},
+		{http.MethodGet, "/note/1", "HTTP://LOCALHOST:5173", "", "", http.StatusOK, "HTTP://LOCALHOST:5173", "origine con pattern"},
+		{http.MethodGet, "/note/1", "https://altro.it", "", "", http.StatusOK, "", "origine non ammessa"},
+		{http.MethodDelete, "/note/1", "https://app.esempio.it", "", "", http.StatusMethodNotAllowed, "https://app.esempio.it", "errore leggibile"},
+		{http.MethodOptions, "/note/1", "https://app.esempio.it", "PUT", "content-type, if-match", http.StatusNoContent, "https://app.esempio.it", "verifica superata"},
+		{http.MethodOptions, "/note/1", "https://app.esempio.it", "DELETE", "", http.StatusNoContent, "", "metodo non associato"},
+		{http.MethodOptions, "/note/1", "https://app.esempio.it", "PUT", "x-segreto", http.StatusNoContent, "", "intestazione non ammessa"},
+		{http.MethodOptions, "/note/1", "https://altro.it", "GET", "", http.StatusNoContent, "", "verifica origine non ammessa"},
+		{http.MethodOptions, "/mancante", "https://app.esempio.it", "GET", "", http.StatusNotFound, "", "percorso mancante"},
+	}
+	for _, d := range dati {
+		r := httptest.NewRequest(d.metodo, d.path, nil)
+		if d.origine != "" {
+			r.Header.Set("Origin", d.origine)
+		}
+		if d.richiesto != "" {
+			r.Header.Set("Access-Control-Request-Method", d.richiesto)
+			r.Header.Set("Access-Control-Request-Headers", d.intestazioni)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		h := w.Header()
+		switch {
+		case (w.Code != d.codice) || (h.Get("Access-Control-Allow-Origin") != d.consentita):
+			t.Errorf("ERR : %s: codice %d origine %q invece di %d %q \n", d.descrizione, w.Code, h.Get("Access-Control-Allow-Origin"), d.codice, d.consentita)
+		case (d.richiesto != "") && (d.consentita != "") && ((h.Get("Access-Control-Allow-Methods") != d.richiesto) || (h.Get("Access-Control-Max-Age") != "600")):
+			t.Errorf("ERR : %s: metodi %q durata %q \n", d.descrizione, h.Get("Access-Control-Allow-Methods"), h.Get("Access-Control-Max-Age"))
+		case (d.richiesto == "") && (d.consentita != "") && (h.Get("Access-Control-Expose-Headers") != "ETag"):
+			t.Errorf("ERR : %s: intestazioni esposte %q \n", d.descrizione, h.Get("Access-Control-Expose-Headers"))
+		default:
+			t.Logf("MSG : %s: codice %d origine %q \n", d.descrizione, w.Code, h.Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	for _, opt := range []CORSOptions{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"https://[esempio.it"}},
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidOrigin) {
+					t.Errorf("ERR : %v: panic %v invece di ErrInvalidOrigin \n", opt.AllowedOrigins, err)
+				}
+			}()
+			sm.CORS(opt)
+		}()
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	proxy, _ := parseProxies([]string{"10.0.0.0/8", "::1"})
+	indirizzi := []struct {
+		remoto, inoltrato string
+		client            string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "203.0.113.9", "192.0.2.1"},
+		{"10.0.0.1:1234", "203.0.113.9", "203.0.113.9"},
+		{"10.0.0.1:1234", "198.51.100.7, 203.0.113.9, 10.0.0.2", "203.0.113.9"},
+		{"[::1]:1234", "10.0.0.3", "10.0.0.3"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+	}
+	for _, d := range indirizzi {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = d.remoto
+		if d.inoltrato != "" {
+			r.Header.Set("X-Forwarded-For", d.inoltrato)
+		}
+		if ip := clientIP(r, proxy); ip != d.client {
+			t.Errorf("ERR : %s [%s]: client %s invece di %s \n", d.remoto, d.inoltrato, ip, d.client)
+		} else {
+			t.Logf("MSG : %s [%s]: client %s \n", d.remoto, d.inoltrato, ip)
+		}
+	}
+
+	sm := nuovoGestoreProva()
+	limite := sm.RateLimit(RateLimitOptions{Rate: 0.5, Burst: 2, TrustedProxies: []string{"10.0.0.0/8"}})
+	sm.EnlistMethodOK(http.MethodPost, "/inserisci", Chain(rispondi("inserita"), limite))
+	sm.EnlistFuncOK("/libero", rispondi("libero"))
+	richieste := []struct {
+		path, remoto, inoltrato string
+		codice                  int
+	}{
+		{"/inserisci", "192.0.2.1:1000", "", http.StatusOK},
+		{"/inserisci", "192.0.2.1:1001", "", http.StatusOK},
+		{"/inserisci", "192.0.2.1:1002", "", http.StatusTooManyRequests},
+		{"/libero", "192.0.2.1:1003", "", http.StatusOK},
+		{"/inserisci", "192.0.2.2:1000", "", http.StatusOK},
+		{"/inserisci", "10.0.0.1:1000", "203.0.113.9", http.StatusOK},
+		{"/inserisci", "10.0.0.1:1000", "203.0.113.9", http.StatusOK},
+		{"/inserisci", "10.0.0.1:1000", "203.0.113.9", http.StatusTooManyRequests},
+		{"/inserisci", "10.0.0.1:1000", "203.0.113.10", http.StatusOK},
+	}
+	for _, d := range richieste {
+		r := httptest.NewRequest(http.MethodPost, d.path, nil)
+		r.RemoteAddr = d.remoto
+		if d.inoltrato != "" {
+			r.Header.Set("X-Forwarded-For", d.inoltrato)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		attesa := w.Header().Get("Retry-After")
+		switch {
+		case w.Code != d.codice:
+			t.Errorf("ERR : %s %s [%s]: codice %d invece di %d \n", d.path, d.remoto, d.inoltrato, w.Code, d.codice)
+		case (d.codice == http.StatusTooManyRequests) && (attesa != "2"):
+			t.Errorf("ERR : %s %s: Retry-After %q invece di \"2\" \n", d.path, d.remoto, attesa)
+		default:
+			t.Logf("MSG : %s %s [%s]: codice %d Retry-After %q \n", d.path, d.remoto, d.inoltrato, w.Code, attesa)
+		}
+	}
+
+	// i client inattivi sono eliminati quando il secchio è pieno o quando sono troppi
+	rl := &rateLimiter{rate: 1, burst: 2, max: 2, clients: make(map[string]*list.Element), recent: list.New()}
+	inizio := time.Now()
+	rl.allow("a", inizio)
+	rl.allow("b", inizio)
+	rl.allow("c", inizio)
+	if _, ok := rl.clients["a"]; ok || (len(rl.clients) != 2) {
+		t.Errorf("ERR : client %d dopo l'eliminazione del meno recente \n", len(rl.clients))
+	}
+	rl.allow("d", inizio.Add(3*time.Second))
+	if len(rl.clients) != 1 {
+		t.Errorf("ERR : client %d dopo l'eliminazione degli inattivi \n", len(rl.clients))
+	} else {
+		t.Logf("MSG : client inattivi eliminati \n")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hash, err := HashPassword("segreta")
+	if err != nil {
+		t.Fatalf("ERR : HashPassword: %s \n", err.Error())
+	}
+	file := t.TempDir() + "/utenti.txt"
+	os.WriteFile(file, []byte("# utenti di prova\n\nmario:"+hash+"\n"), 0600)
+	utenti, err := LoadBasicAuth("Prova", file)
+	if err != nil {
+		t.Fatalf("ERR : LoadBasicAuth: %s \n", err.Error())
+	}
+	os.WriteFile(file, []byte("mario:segreta\n"), 0600)
+	if _, err = LoadBasicAuth("Prova", file); !errors.Is(err, ErrInvalidUsersFile) {
+		t.Errorf("ERR : LoadBasicAuth con password in chiaro: %v invece di ErrInvalidUsersFile \n", err)
+	}
+
+	sessioni, err := NewSessionAuth(SessionOptions{})
+	if err != nil {
+		t.Fatalf("ERR : NewSessionAuth: %s \n", err.Error())
+	}
+	token := NewTokenAuth(map[string]string{"abc123": "script"})
+
+	sm := nuovoGestoreProva()
+	sm.Use(sm.Authenticate(sessioni, utenti, token))
+	sm.EnlistFuncOK("/accedi", func(w http.ResponseWriter, r *http.Request) {
+		sessioni.Login(w, r, r.FormValue("nome"))
+	})
+	sm.EnlistOK("/riservata", Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := RequestPrincipal(r)
+		io.WriteString(w, p.Scheme+":"+p.Name)
+	}), sm.RequireAuth("/accedi")))
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/accedi?nome=lucia", nil))
+	cookie := w.Result().Cookies()[0]
+	alterato := *cookie
+	alterato.Value = cookie.Value[:len(cookie.Value)-2] + "AA"
+
+	dati := []struct {
+		descrizione string
+		prepara     func(r *http.Request)
+		codice      int
+		risposta    string
+	}{
+		{"anonima JSON", func(r *http.Request) { r.Header.Set("Accept", "application/json") }, http.StatusUnauthorized, ""},
+		{"anonima HTML", func(r *http.Request) { r.Header.Set("Accept", "text/html") }, http.StatusSeeOther, ""},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("mario", "segreta") }, http.StatusOK, "basic:mario"},
+		{"basic errata", func(r *http.Request) { r.SetBasicAuth("mario", "sbagliata") }, http.StatusUnauthorized, ""},
+		{"basic sconosciuto", func(r *http.Request) { r.SetBasicAuth("anna", "segreta") }, http.StatusUnauthorized, ""},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc123") }, http.StatusOK, "bearer:script"},
+		{"bearer errato", func(r *http.Request) { r.Header.Set("Authorization", "Bearer xyz") }, http.StatusUnauthorized, ""},
+		{"sessione", func(r *http.Request) { r.AddCookie(cookie) }, http.StatusOK, "session:lucia"},
+		{"sessione alterata", func(r *http.Request) { r.AddCookie(&alterato); r.Header.Set("Accept", "application/json") }, http.StatusUnauthorized, ""},
+	}
+	for _, d := range dati {
+		r := httptest.NewRequest(http.MethodGet, "/riservata", nil)
+		d.prepara(r)
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		switch {
+		case (w.Code != d.codice) || ((d.risposta != "") && (w.Body.String() != d.risposta)):
+			t.Errorf("ERR : %s: codice %d risposta %q invece di %d %q \n", d.descrizione, w.Code, w.Body.String(), d.codice, d.risposta)
+		case (w.Code == http.StatusUnauthorized) && (len(w.Header().Values("WWW-Authenticate")) != 2):
+			t.Errorf("ERR : %s: WWW-Authenticate %v \n", d.descrizione, w.Header().Values("WWW-Authenticate"))
+		case (w.Code == http.StatusSeeOther) && (w.Header().Get("Location") != "/accedi?next=%2Friservata"):
+			t.Errorf("ERR : %s: Location %q \n", d.descrizione, w.Header().Get("Location"))
+		default:
+			t.Logf("MSG : %s: codice %d risposta %q \n", d.descrizione, w.Code, w.Body.String())
+		}
+	}
+
+	// dopo Logout il cookie non è più valido
+	r := httptest.NewRequest(http.MethodPost, "/esci", nil)
+	r.AddCookie(cookie)
+	sessioni.Logout(httptest.NewRecorder(), r)
+	if p, err := sessioni.Authenticate(r); (p != nil) || !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("ERR : sessione dopo Logout: %v %v \n", p, err)
+	} else {
+		t.Logf("MSG : sessione terminata: %v \n", err)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	type modulo struct {
+		Nota string `form:"nota"`
+	}
+	sm := nuovoGestoreProva()
+	sm.Use(sm.Authenticate(NewTokenAuth(map[string]string{"abc123": "script"})),
+		sm.CSRF(CSRFOptions{TrustedOrigins: []string{"https://*.fidato.it"}}))
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, CSRFToken(r))
+	})
+	sm.EnlistMethodFuncOK(http.MethodPost, "/note", func(w http.ResponseWriter, r *http.Request) {
+		var m modulo
+		if DecodeForm(r, &m, 0, w) == nil {
+			io.WriteString(w, m.Nota)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/note", nil))
+	cookie := w.Result().Cookies()[0]
+	token := w.Body.String()
+	if (cookie.Name != DefaultCSRFCookie) || !cookie.HttpOnly || (token == "") {
+		t.Fatalf("ERR : cookie %v token %q \n", cookie, token)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/note", nil)
+	r.AddCookie(cookie)
+	sm.ServeHTTP(w, r)
+	if (w.Body.String() == token) || (len(w.Result().Cookies()) != 0) {
+		t.Errorf("ERR : token ripetuto o cookie reimpostato \n")
+	}
+
+	dati := []struct {
+		descrizione string
+		corpo       string
+		prepara     func(r *http.Request)
+		codice      int
+	}{
+		{"campo del modulo", "nota=prova&" + DefaultCSRFField + "=" + token, func(r *http.Request) { r.AddCookie(cookie) }, http.StatusOK},
+		{"intestazione", "nota=prova", func(r *http.Request) { r.AddCookie(cookie); r.Header.Set(DefaultCSRFHeader, token) }, http.StatusOK},
+		{"stessa origine", "nota=prova", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(DefaultCSRFHeader, token)
+			r.Header.Set("Origin", "http://example.com")
+			r.Header.Set("Sec-Fetch-Site", "same-origin")
+		}, http.StatusOK},
+		{"origine fidata", "nota=prova", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(DefaultCSRFHeader, token)
+			r.Header.Set("Origin", "https://app.fidato.it")
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, http.StatusOK},
+		{"bearer senza token", "nota=prova", func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc123") }, http.StatusOK},
+		{"token mancante", "nota=prova", func(r *http.Request) { r.AddCookie(cookie) }, http.StatusForbidden},
+		{"token errato", "nota=prova", func(r *http.Request) { r.AddCookie(cookie); r.Header.Set(DefaultCSRFHeader, NewToken()) }, http.StatusForbidden},
+		{"cookie mancante", "nota=prova", func(r *http.Request) { r.Header.Set(DefaultCSRFHeader, token) }, http.StatusForbidden},
+		{"altro sito", "nota=prova", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(DefaultCSRFHeader, token)
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, http.StatusForbidden},
+		{"altra origine", "nota=prova", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set(DefaultCSRFHeader, token)
+			r.Header.Set("Origin", "http://attacco.it")
+		}, http.StatusForbidden},
+		{"bearer errato", "nota=prova", func(r *http.Request) { r.Header.Set("Authorization", "Bearer xyz") }, http.StatusForbidden},
+	}
+	for _, d := range dati {
+		r := httptest.NewRequest(http.MethodPost, "/note", strings.NewReader(d.corpo))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		d.prepara(r)
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, r)
+		if (w.Code != d.codice) || ((w.Code == http.StatusOK) && (w.Body.String() != "prova")) {
+			t.Errorf("ERR : %s: codice %d risposta %q invece di %d \n", d.descrizione, w.Code, w.Body.String(), d.codice)
+		} else {
+			t.Logf("MSG : %s: codice %d \n", d.descrizione, w.Code)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metriche := NewMetrics([]float64{0.1, 1})
+	metriche.GaugeFunc("prova_valore", "Valore di prova.", func() float64 { return 2.5 })
+	metriche.GaugeVecFunc("prova_note", "Note per stato.", "stato", func() map[string]float64 {
+		return map[string]float64{"fatte": 1, "da \"fare\"": 3}
+	})
+	sm := nuovoGestoreProva()
+	// il secondo middleware cambia il percorso: l'etichetta è quella del percorso servito
+	sm.Use(sm.Instrument(metriche), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id, ok := strings.CutPrefix(r.URL.Path, "/vecchio/"); ok {
+				r = r.Clone(r.Context())
+				r.URL.Path = "/note/" + id
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	sm.EnlistMethodFuncOK(http.MethodGet, "/note/{id}", rispondi("nota"))
+	sm.EnlistMethodFuncOK(http.MethodGet, "/files/", rispondi("file"))
+	sm.EnlistMethodOK(http.MethodGet, "/metrics", metriche)
+
+	for _, p := range []string{"/note/1", "/note/2", "/vecchio/3", "/altro", "/files/a.css", "/files/img/b.png"} {
+		sm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	sm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/note/1", nil))
+	sm.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROVA", "/note/1", nil))
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Header().Get("Content-Type") != MetricsMediaType {
+		t.Errorf("ERR : Content-Type %q \n", w.Header().Get("Content-Type"))
+	}
+	attese := []string{
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{method="GET",route="/note/{id}",status="200"} 3` + "\n",
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1` + "\n",
+		`http_requests_total{method="GET",route="/files/",status="200"} 2` + "\n",
+		`http_requests_total{method="POST",route="/note/{id}",status="405"} 1` + "\n",
+		`http_requests_total{method="OTHER",route="/note/{id}",status="405"} 1` + "\n",
+		"# TYPE http_request_duration_seconds histogram\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/note/{id}",status="200",le="0.1"} 3` + "\n",
+		`http_request_duration_seconds_bucket{method="GET",route="/note/{id}",status="200",le="+Inf"} 3` + "\n",
+		`http_request_duration_seconds_count{method="GET",route="/note/{id}",status="200"} 3` + "\n",
+		"http_requests_in_flight 1\n",
+		"prova_valore 2.5\n",
+		`prova_note{stato="da \"fare\""} 3` + "\n",
+		`prova_note{stato="fatte"} 1` + "\n",
+	}
+	for _, a := range attese {
+		if !strings.Contains(w.Body.String(), a) {
+			t.Errorf("ERR : riga mancante %q \n", a)
+		}
+	}
+	t.Logf("MSG : metriche:\n%s", w.Body.String())
+
+	dati := []struct {
+		descrizione string
+		registra    func()
+	}{
+		{"nome non valido", func() { metriche.GaugeFunc("prova-valore", "", nil) }},
+		{"nome ripetuto", func() { metriche.GaugeFunc("prova_valore", "", nil) }},
+		{"nome riservato", func() { metriche.GaugeFunc("http_requests_total", "", nil) }},
+		{"etichetta non valida", func() { metriche.GaugeVecFunc("prova_altro", "", "1a", nil) }},
+		{"intervalli non ordinati", func() { NewMetrics([]float64{1, 0.5}) }},
+	}
+	for _, d := range dati {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidMetric) {
+					t.Errorf("ERR : %s: panic %v invece di ErrInvalidMetric \n", d.descrizione, err)
+				} else {
+					t.Logf("MSG : %s: %v \n", d.descrizione, err)
+				}
+			}()
+			d.registra()
+		}()
+	}
+}
+
+func TestHealth(t *testing.T) {
+	salute := NewHealth(HealthOptions{Timeout: 50 * time.Millisecond, Cache: time.Minute})
+	var esecuzioni atomic.Int32
+	guasto := errors.New("database non raggiungibile")
+	salute.AddLivenessCheck("processo", func(ctx context.Context) error { return nil })
+	salute.AddReadinessCheck("database", func(ctx context.Context) error {
+		esecuzioni.Add(1)
+		return guasto
+	})
+	salute.AddReadinessCheck("lento", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	dati := []struct {
+		descrizione string
+		gestore     http.HandlerFunc
+		codice      int
+		controlli   map[string]string
+	}{
+		{"vivacità", salute.ServeLiveness, http.StatusOK, map[string]string{"processo": ""}},
+		{"prontezza", salute.ServeReadiness, http.StatusServiceUnavailable,
+			map[string]string{"processo": "", "database": guasto.Error(), "lento": context.DeadlineExceeded.Error()}},
+		{"prontezza dalla cache", salute.ServeReadiness, http.StatusServiceUnavailable,
+			map[string]string{"processo": "", "database": guasto.Error(), "lento": context.DeadlineExceeded.Error()}},
+	}
+	for _, d := range dati {
+		w := httptest.NewRecorder()
+		d.gestore(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var rep HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &rep); (err != nil) || (w.Code != d.codice) || (len(rep.Checks) != len(d.controlli)) {
+			t.Errorf("ERR : %s: codice %d risposta %s \n", d.descrizione, w.Code, w.Body.String())
+			continue
+		}
+		for nome, errore := range d.controlli {
+			if res := rep.Checks[nome]; res.Error != errore {
+				t.Errorf("ERR : %s: controllo %s con errore %q invece di %q \n", d.descrizione, nome, res.Error, errore)
+			}
+		}
+		t.Logf("MSG : %s: codice %d risposta %s \n", d.descrizione, w.Code, w.Body.String())
+	}
+	if n := esecuzioni.Load(); n != 1 {
+		t.Errorf("ERR : controllo eseguito %d volte invece di una \n", n)
+	}
+
+	salute.Shutdown(context.Background())
+	w := httptest.NewRecorder()
+	salute.ServeReadiness(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if (w.Code != http.StatusServiceUnavailable) || !strings.Contains(w.Body.String(), ErrShuttingDown.Error()) {
+		t.Errorf("ERR : prontezza in chiusura: codice %d risposta %s \n", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	salute.ServeLiveness(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("ERR : vivacità in chiusura: codice %d \n", w.Code)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "certificati", "cert.pem"), filepath.Join(dir, "certificati", "key.pem")
+	if err := GenerateCertificate(cert, key, nil); err != nil {
+		t.Fatalf("ERR : certificato non creato: %v \n", err)
+	}
+	cr, err := NewCertReloader(cert, key, time.Nanosecond, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("ERR : certificato non caricato: %v \n", err)
+	}
+
+	// nomi restituisce i nomi del certificato in uso
+	nomi := func() string {
+		c, _ := cr.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return err.Error()
+		}
+		return strings.Join(leaf.DNSNames, ",")
+	}
+	dati := []struct {
+		descrizione string
+		prepara     func() error
+		nomi        string
+	}{
+		{"certificato creato", func() error { return nil }, "localhost"},
+		{"certificato rinnovato", func() error { return GenerateCertificate(cert, key, []string{"esempio.it", "www.esempio.it"}) }, "esempio.it,www.esempio.it"},
+		{"chiave non valida", func() error { return os.WriteFile(key, []byte("non valida"), 0600) }, "esempio.it,www.esempio.it"},
+	}
+	for _, d := range dati {
+		if err := d.prepara(); err != nil {
+			t.Fatalf("ERR : %s: %v \n", d.descrizione, err)
+		}
+		time.Sleep(time.Millisecond)
+		if n := nomi(); n != d.nomi {
+			t.Errorf("ERR : %s: nomi %q invece di %q \n", d.descrizione, n, d.nomi)
+			continue
+		}
+		t.Logf("MSG : %s: nomi %q \n", d.descrizione, d.nomi)
+	}
+
+	reindirizzi := []struct {
+		indirizzo string
+		metodo    string
+		url       string
+		codice    int
+		posizione string
+	}{
+		{":443", http.MethodGet, "http://esempio.it/nota/1?x=2", http.StatusMovedPermanently, "https://esempio.it/nota/1?x=2"},
+		{":8443", http.MethodGet, "http://localhost:8080/", http.StatusMovedPermanently, "https://localhost:8443/"},
+		{":8443", http.MethodPost, "http://[::1]:8080/inserisci", http.StatusPermanentRedirect, "https://[::1]:8443/inserisci"},
+	}
+	for _, d := range reindirizzi {
+		w := httptest.NewRecorder()
+		RedirectHTTPS(d.indirizzo).ServeHTTP(w, httptest.NewRequest(d.metodo, d.url, nil))
+		if (w.Code != d.codice) || (w.Header().Get("Location") != d.posizione) {
+			t.Errorf("ERR : %s %s: codice %d posizione %q \n", d.metodo, d.url, w.Code, w.Header().Get("Location"))
+			continue
+		}
+		t.Logf("MSG : %s %s: posizione %q \n", d.metodo, d.url, d.posizione)
+	}
+
+	hsts := Chain(rispondi("ok"), HSTS(HSTSOptions{MaxAge: time.Hour, IncludeSubdomains: true}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	hsts.ServeHTTP(w, r)
+	if v := w.Header().Get("Strict-Transport-Security"); v != "" {
+		t.Errorf("ERR : HSTS senza TLS: %q \n", v)
+	}
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	hsts.ServeHTTP(w, r)
+	if v := w.Header().Get("Strict-Transport-Security"); v != "max-age=3600; includeSubDomains" {
+		t.Errorf("ERR : HSTS con TLS: %q \n", v)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"files/stili.css": {Data: []byte("body { color: black; }"), ModTime: time.Now()},
+		"img/logo.png":    {Data: []byte("png")},
+		"LICENSE":         {Data: []byte("licenza")},
+	}
+	sm := nuovoGestoreProva()
+	sm.EnlistStatusReply(http.StatusNotFound, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "mancante")
+	}))
+	statici := sm.Static(fsys, StaticOptions{Prefix: "/static/", MaxAge: time.Hour})
+	css := statici.URL("/static/files/stili.css")
+	licenza := statici.URL("/static/LICENSE")
+	if !regexp.MustCompile(`^/static/files/stili\.[0-9a-f]{16}\.css$`).MatchString(css) || !regexp.MustCompile(`^/static/LICENSE\.[0-9a-f]{16}$`).MatchString(licenza) {
+		t.Fatalf("ERR : percorsi con impronta %q %q \n", css, licenza)
+	}
+	if u := statici.URL("/static/files/assente.css"); u != "/static/files/assente.css" {
+		t.Errorf("ERR : percorso di un file assente %q \n", u)
+	}
+
+	dati := []struct {
+		percorso string
+		codice   int
+		cache    string
+		corpo    string
+	}{
+		{css, http.StatusOK, StaticImmutableCache, "body { color: black; }"},
+		{licenza, http.StatusOK, StaticImmutableCache, "licenza"},
+		{"/static/files/stili.css", http.StatusOK, "public, max-age=3600", "body { color: black; }"},
+		{"/static/files/stili.0123456789abcdef.css", http.StatusOK, "public, max-age=3600", "body { color: black; }"},
+		{"/static/img/logo.png", http.StatusOK, "public, max-age=3600", "png"},
+		{"/static/files/", http.StatusNotFound, "", "mancante"},
+		{"/static/files/assente.css", http.StatusNotFound, "", "mancante"},
+		{"/static/files/assente.0123456789abcdef.css", http.StatusNotFound, "", "mancante"},
+		{"/altro/files/stili.css", http.StatusNotFound, "", "mancante"},
+	}
+	for _, d := range dati {
+		w := httptest.NewRecorder()
+		statici.ServeHTTP(w, httptest.NewRequest(http.MethodGet, d.percorso, nil))
+		if (w.Code != d.codice) || (w.Header().Get("Cache-Control") != d.cache) || (w.Body.String() != d.corpo) {
+			t.Errorf("ERR : %s: codice %d cache %q corpo %q \n", d.percorso, w.Code, w.Header().Get("Cache-Control"), w.Body.String())
+			continue
+		}
+		t.Logf("MSG : %s: codice %d cache %q \n", d.percorso, w.Code, d.cache)
+	}
+
+	// richiesta condizionale con l'ETag dell'impronta
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/static/files/stili.css", nil)
+	r.Header.Set("If-None-Match", `"`+css[len("/static/files/stili."):len(css)-len(".css")]+`"`)
+	statici.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("ERR : richiesta condizionale: codice %d \n", w.Code)
+	}
+
+	// il file modificato ha una nuova impronta
+	fsys["files/stili.css"] = &fstest.MapFile{Data: []byte("body { color: red; }"), ModTime: time.Now().Add(time.Second)}
+	if nuovo := statici.URL("/static/files/stili.css"); nuovo == css {
+		t.Errorf("ERR : impronta non aggiornata dopo la modifica: %q \n", nuovo)
+	}
+	w = httptest.NewRecorder()
+	statici.ServeHTTP(w, httptest.NewRequest(http.MethodGet, css, nil))
+	if (w.Header().Get("Cache-Control") != "public, max-age=3600") || (w.Body.String() != "body { color: red; }") {
+		t.Errorf("ERR : impronta precedente: cache %q corpo %q \n", w.Header().Get("Cache-Control"), w.Body.String())
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa libreria è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package webman
+
+import (
+	"net/http"
+)
+
+// ===== Tipo responseWriter =====
+
+// responseWriter avvolge un http.ResponseWriter per registrare codice di stato
+// e numero di byte della risposta.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+// newResponseWriter restituisce w se registra già la risposta, altrimenti lo avvolge.
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	// i codici 1xx non completano l'intestazione
+	if rw.status == 0 && code >= 200 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush invia al client i dati scritti se il ResponseWriter avvolto implementa http.Flusher.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap restituisce il ResponseWriter avvolto, usato da http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// started restituisce true se l'intestazione della risposta è già stata inviata.
+func (rw *responseWriter) started() bool {
+	return (rw.status != 0)
+}
+
+// statusCode restituisce il codice di stato della risposta, http.StatusOK se il gestore non ha scritto nulla.
+func (rw *responseWriter) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
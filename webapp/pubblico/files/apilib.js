@@ -28,13 +28,20 @@ function mostraInfoNota(id) {
       }
    };
    // imposta la richiesta
-   req.open("GET", "/api/mostra/nota?id="+id, true);
-   req.setRequestHeader("Accept", "application/json");
+   req.open("GET", "/api/note/"+id, true);
+   req.setRequestHeader("Accept", "application/json, application/problem+json");
    // invia la richiesta
    req.send();
 }
 
 
+//tokenCSRF restituisce il token CSRF della pagina, da inviare con le richieste che modificano le note.
+function tokenCSRF() {
+   var meta = document.querySelector('meta[name="csrf-token"]');
+   return (meta != null) ? meta.content : "";
+}
+
+
 //analizzaRisposta analizza la risposta ricevuta da una richiesta per riportare eventuali errori.
 //Verifica il formato della risposta e mostra all'utente il messaggio di errore in JSON,
 //oppure testo e codice di stato se diverso da OK (200) per altri formati.
@@ -44,11 +51,24 @@ function analizzaRisposta(req) {
    var ct = req.getResponseHeader("Content-Type").split(";",1)[0];
    //verifica il formato
    switch (ct) {
+      case "application/problem+json":
+         //formato problema (RFC 9457), estrae la descrizione dell'errore
+         try {
+            info = descriviProblema(JSON.parse(req.responseText), req.status);
+         }
+         catch(err) {
+            //decodifica JSON fallita, imposta un messaggio di errore con il codice di stato
+            info = "Risposta Server: " + req.status;
+         }
+         break;
       case "application/json":
          //formato JSON, cerca di estrarre i dati di un oggetto RisultatoAPI
          try {
             var risAPI = JSON.parse(req.responseText);
-            if (risAPI.ok == false) {
+            if (req.status >= 400) {
+               //problema inviato con il tipo application/json
+               info = descriviProblema(risAPI, req.status);
+            } else if (risAPI.ok == false) {
                //operazione non riuscita
                info = risAPI.msg;
                if (info.length < 1) {
@@ -84,6 +104,19 @@ function analizzaRisposta(req) {
 }
 
 
+//descriviProblema restituisce il messaggio di errore di un problema (RFC 9457):
+//la descrizione, oppure il titolo, seguita dagli eventuali errori dei campi della nota.
+function descriviProblema(problema, stato) {
+   var info = problema.detail || problema.title || ("Operazione non riuscita. Risposta Server: " + stato);
+   if (Array.isArray(problema.errori)) {
+      for (var i = 0; i < problema.errori.length; i++) {
+         info += "\n" + problema.errori[i].messaggio;
+      }
+   }
+   return info;
+}
+
+
 //cambiaTestoNota permette all'utente di modificare il testo di una nota.
 //link rappresenta il link che racchiude il testo della nota, id e fatto sono i dati della nota
 function cambiaTestoNota(link, id, fatto) {
@@ -108,21 +141,23 @@ function cambiaTestoNota(link, id, fatto) {
       }
    };
    //crea l'oggetto napi da codificare in JSON per la richiesta
-   var napi = {"id":id, "nota":txt, "fatto":fatto, "valida":true};
+   var napi = {"id":id, "nota":txt, "fatto":fatto};
    //imposta la richiesta che invia i dati al percorso di modifica
    req.open("POST", "/aggiorna", true);
    req.setRequestHeader("Content-Type", "application/json");
-   req.setRequestHeader("Accept", "application/json");
+   req.setRequestHeader("Accept", "application/json, application/problem+json");
+   req.setRequestHeader("X-CSRF-Token", tokenCSRF());
    //invia la richiesta con l'oggetto napi codificato
    req.send(JSON.stringify(napi));
 }
 
 
 //cambiaStatoNota permette all'utente di modificare lo stato di una nota.
-//link rappresenta il link che racchiude l'immagine dello stato Fatto\Non Fatto, id e fatto sono i dati della nota
-function cambiaStatoNota(link, id, fatto) {
-   //recupera il persorso del link
-   var path = link.href;
+//modulo rappresenta il modulo con il pulsante che racchiude l'immagine dello stato Fatto\Non Fatto
+function cambiaStatoNota(modulo) {
+   //recupera il percorso del modulo e il pulsante
+   var path = modulo.action;
+   var pulsante = modulo.querySelector("button");
    var imgHTML;
    //verifica lo stato da impostare
    if (path.endsWith("true")) {
@@ -148,16 +183,17 @@ function cambiaStatoNota(link, id, fatto) {
             //mostra il messaggio di errore
             alert(info);
          } else {
-            //sostituisce il percorso del link e l'immagine
-            link.href = path;
-            link.innerHTML = imgHTML;
+            //sostituisce il percorso del modulo e l'immagine
+            modulo.action = path;
+            pulsante.innerHTML = imgHTML;
          }
       }
    };
    //imposta la richiesta che punta al percorso di cambio stato
-   req.open("POST", link.href, true);
+   req.open("POST", modulo.action, true);
    req.setRequestHeader("Content-Type", "application/json");
-   req.setRequestHeader("Accept", "application/json");
+   req.setRequestHeader("Accept", "application/json, application/problem+json");
+   req.setRequestHeader("X-CSRF-Token", tokenCSRF());
    //invia la richiesta
    req.send();
 }
@@ -6,54 +6,132 @@ package main
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"net/http"
-	"strconv"
+	"time"
 
 	"rmite/todo"
 	web "rmite/webman"
 )
 
 //NotaAPI rappresenta una nota per le api.
+//
+//Il corpo è in formato Markdown e CorpoHTML contiene la sua conversione in HTML sicuro.
+//Nelle richieste di aggiornamento il corpo resta invariato se il campo "corpo" è assente.
 type NotaAPI struct {
-	ID     int64  `json:"id"`
-	Testo  string `json:"nota"`
-	Fatto  bool   `json:"fatto"`
-	Valida bool   `json:"valida"`
+	ID        int64   `json:"id" validate:"required"`
+	Testo     string  `json:"nota"`
+	Corpo     *string `json:"corpo"`
+	CorpoHTML string  `json:"corpo_html,omitempty"`
+	Fatto     bool    `json:"fatto"`
+	Valida    bool    `json:"valida"`
 }
 
-//RisultatoAPI descrive il risultato di un'operazione via api.
+//RisultatoAPI descrive il risultato di un'operazione riuscita via api.
+//Gli errori sono inviati come problemi in formato application/problem+json,
+//con gli eventuali errori di convalida dei campi della nota nel membro "errori".
 type RisultatoAPI struct {
 	OK        bool   `json:"ok"`
 	Messaggio string `json:"msg"`
 }
 
-//leggiNotaAPI legge la nota in formato json contenuta nel corpo di una richiesta.
-func leggiNotaAPI(r *http.Request) (napi NotaAPI) {
-	if (r.Body != nil) && (r.ContentLength > 0) {
-		dati := make([]byte, r.ContentLength)
-		if _, err := r.Body.Read(dati); err != nil {
-			if json.Unmarshal(dati, &napi) != nil {
-				napi.Valida = false
-			}
-		}
+//NotaEsportata rappresenta una nota nell'esportazione dell'elenco.
+type NotaEsportata struct {
+	XMLName xml.Name `json:"-" xml:"nota" csv:"-"`
+	ID      int64    `json:"id" xml:"id,attr" csv:"id"`
+	Testo   string   `json:"nota" xml:"testo" csv:"nota"`
+	Corpo   string   `json:"corpo" xml:"corpo" csv:"corpo"`
+	Fatto   bool     `json:"fatto" xml:"fatto" csv:"fatto"`
+}
+
+//String restituisce la nota esportata in formato testo.
+func (ne NotaEsportata) String() string {
+	if ne.Fatto {
+		return "[x] " + ne.Testo
 	}
+	return "[ ] " + ne.Testo
+}
+
+//formatiEsportazione associa i valori del parametro formato ai tipi di contenuto dell'esportazione.
+var formatiEsportazione = map[string]string{
+	"json":   "application/json",
+	"xml":    "application/xml",
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"txt":    "text/plain",
+}
+
+//leggiNotaAPI legge la nota in formato JSON contenuta nel corpo di una richiesta.
+//Restituisce false se il corpo non è valido: in questo caso la risposta con l'errore è già stata inviata.
+func leggiNotaAPI(w http.ResponseWriter, r *http.Request) (napi NotaAPI, ok bool) {
+	ok = (web.DecodeJSON(r, &napi, 0, w) == nil)
 	return
 }
 
+//notaAPI restituisce i dati di una nota per le api.
+func notaAPI(nt *todo.Nota) NotaAPI {
+	corpo := nt.GetCorpo()
+	return NotaAPI{ID: nt.GetID(), Testo: nt.GetTesto(), Corpo: &corpo, CorpoHTML: string(nt.CorpoHTML()), Fatto: nt.Fatto, Valida: nt.Valida()}
+}
+
+//etagNota restituisce l'ETag forte della nota, calcolato dalla sua rappresentazione in JSON.
+//Permette ai client di riusare la nota già ricevuta e di aggiornarla solo se non è cambiata (If-Match).
+func etagNota(nt *todo.Nota) string {
+	dati, _ := json.Marshal(notaAPI(nt))
+	return web.HashETag(dati, false)
+}
+
 //apiMostraNota restituisce i dati di una nota.
+//Risponde con il codice 304 se la richiesta contiene nel valore If-None-Match l'ETag della nota.
 func apiMostraNota(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodGet}, true, w) {
+	gu := gestoreRichiesta(r)
+	var nt *todo.Nota = &todo.Nota{}
+
+	if id, err := web.PathParamInt64(r, "id"); err == nil {
+		nt, _ = gu.Recupera(id)
+	}
+
+	dati, err := json.Marshal(notaAPI(nt))
+	if err != nil {
+		app.ReplyStatus(http.StatusInternalServerError, err.Error(), w, r)
 		return
 	}
+	if web.CheckConditions(r, web.HashETag(dati, false), time.Time{}, w) {
+		web.ServeJSON(r, dati, http.StatusOK, w)
+	}
+}
 
-	idstr := r.FormValue("id")
+//esportaNote invia l'elenco delle note selezionate dal parametro filtro senza raccoglierlo in memoria.
+//Il formato è scelto con il valore Accept dell'intestazione oppure con il parametro formato,
+//ad esempio /esporta?formato=csv&filtro=dafare.
+func esportaNote(w http.ResponseWriter, r *http.Request) {
+	gu := gestoreRichiesta(r)
+	if f := r.FormValue("formato"); f != "" {
+		tipo, ok := formatiEsportazione[f]
+		if !ok {
+			app.ReplyStatus(http.StatusBadRequest, "Formato di esportazione non valido.", w, r)
+			return
+		}
+		r.Header.Set("Accept", tipo)
+		if f != "txt" {
+			w.Header().Set("Content-Disposition", `attachment; filename="note.`+f+`"`)
+		}
+	}
 
-	var nt *todo.Nota = &todo.Nota{}
+	var errScorri error
+	note := web.Stream(func(yield func(item any) bool) {
+		errScorri = gu.Scorri(filtroRichiesta(r), func(nt *todo.Nota) bool {
+			return yield(NotaEsportata{ID: nt.GetID(), Testo: nt.GetTesto(), Corpo: nt.GetCorpo(), Fatto: nt.Fatto})
+		})
+	})
 
-	if id, err := strconv.ParseInt(idstr, 10, 64); err == nil {
-		nt, _ = gn.Recupera(id)
+	err := web.Serve(r, note, http.StatusOK, w,
+		web.JSONEncoder{}, web.XMLEncoder{Root: "note"}, web.CSVEncoder{}, web.NDJSONEncoder{}, web.TextEncoder{})
+	if err == nil {
+		err = errScorri
+	}
+	if err != nil {
+		// la risposta è già iniziata: la interrompe per non inviare un elenco incompleto
+		panic(http.ErrAbortHandler)
 	}
-
-	napi := NotaAPI{ID: nt.GetID(), Testo: nt.GetTesto(), Fatto: nt.Fatto, Valida: nt.Valida()}
-	web.ServeJSON(r, napi, http.StatusOK, w)
 }
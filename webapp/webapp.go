@@ -7,26 +7,61 @@
 package main
 
 import (
+	"context"
+	"embed"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"rmite/todo"
 	web "rmite/webman"
 )
 
+//I template e i file pubblici sono compilati nell'eseguibile.
+//
+//go:embed privato/modelli pubblico
+var incorporati embed.FS
+
 var app *web.ServerManager
 var server *http.Server
+var ciclo *web.Lifecycle
+var risorse fs.FS
+var sviluppo bool
+var statici *web.Static
 var modelli *template.Template
 
 var gn *todo.Gestore
-var filtro todo.FiltroElenco
-var uiMsg string
+var utenti *web.BasicAuth
+var sessioni *web.SessionAuth
+
+//avvisi contiene per ogni utente il messaggio, gli errori di convalida e il testo inserito
+//da mostrare una sola volta nella pagina successiva, anche dopo un reindirizzamento.
+var avvisi = make(map[string]avviso)
+var avvisiMu sync.Mutex
+
+//filtriElenco associa i nomi dei filtri usati nei percorsi /note/{filtro} e nel parametro filtro ai filtri dell'elenco.
+var filtriElenco = map[string]todo.FiltroElenco{
+	"tutte":  todo.NessunFiltro,
+	"dafare": todo.NoteDaFare,
+	"fatte":  todo.NoteFatte,
+	"pronte": todo.NotePronte}
+
+//avviso rappresenta il messaggio temporaneo di un utente.
+type avviso struct {
+	msg    string
+	errori todo.ErroriConvalida
+	valore string
+}
 
 func main() {
 	var err error
@@ -35,80 +70,388 @@ func main() {
 	if gn, err = todo.NewGestore("note.db"); err != nil {
 		log.Fatalln(err)
 	}
-	filtro = todo.NessunFiltro
 
-	//crea la mappa delle funzioni per i template
-	fm := template.FuncMap{
-		"msg":    usaMessaggio,
-		"filtro": recuperaFiltro}
+	//aggiunge le regole di convalida dell'applicazione a quelle di default
+	gn.AggiungiRegole(
+		todo.NessunDuplicato(),
+		todo.CaratteriVietati(todo.CampoTesto, "\r\n\t"),
+		todo.LunghezzaMassima(todo.CampoCorpo, 10000))
+
+	//crea il gestore dell'applicazione
+	app = web.NewServerManager(nil)
+
+	//con la variabile d'ambiente RICORDALISTA_RISORSE, durante lo sviluppo i template e i file pubblici
+	//sono letti dalla cartella indicata, che contiene le sottocartelle privato/modelli e pubblico:
+	//i template sono letti di nuovo a ogni pagina e i file pubblici quando cambiano data o dimensione
+	risorse = incorporati
+	if dir := os.Getenv("RICORDALISTA_RISORSE"); dir != "" {
+		risorse = os.DirFS(dir)
+		sviluppo = true
+	}
+	pubblico, err := fs.Sub(risorse, "pubblico")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	statici = app.Static(pubblico, web.StaticOptions{})
 
 	//inizializza i template
-	if modelli, err = template.New("").Funcs(fm).ParseFiles("privato\\modelli\\home.html", "privato\\modelli\\modifica.html", "privato\\modelli\\elimina.html"); err != nil {
+	if modelli, err = caricaModelli(); err != nil {
 		log.Fatalln(err)
 	}
 
-	//crea il gestore dell'applicazione
-	app = web.NewServerManager(nil)
+	//carica gli utenti e prepara le sessioni
+	if utenti, err = caricaUtenti("privato/utenti.txt"); err != nil {
+		log.Fatalln(err)
+	}
+	if sessioni, err = web.NewSessionAuth(web.SessionOptions{CookieName: "ricordalista"}); err != nil {
+		log.Fatalln(err)
+	}
 
-	//imposta i percorsi
-	app.EnlistFuncOK("/", mostraHomepage)
-	app.EnlistFuncOK("/inserisci", aggiungiNota)
-	app.EnlistFuncOK("/modifica", modificaNota)
-	app.EnlistFuncOK("/aggiorna", aggiornaNota)
-	app.EnlistFuncOK("/cambia", cambiaStato)
-	app.EnlistFuncOK("/avviso/rimuovi", avvisoRimuovi)
-	app.EnlistFuncOK("/conferma/rimuovi", rimuoviNota)
-	app.EnlistFuncOK("/chiudi", chiudiApp)
-	app.EnlistFuncOK("/api/mostra/nota", apiMostraNota)
-
-	//imposta il gestore dei file
-	fs := http.FileServer(http.Dir(".\\pubblico"))
-	app.EnlistOK("/img/", fs)
-	app.EnlistOK("/files/", fs)
-
-	//crea il server
+	//token delle API per i client, nel formato nome:token separati da virgole
+	//nella variabile d'ambiente RICORDALISTA_TOKEN
+	token := web.NewTokenAuth(nil)
+	for _, v := range strings.Split(os.Getenv("RICORDALISTA_TOKEN"), ",") {
+		if nome, t, ok := strings.Cut(strings.TrimSpace(v), ":"); ok && (nome != "") && (t != "") {
+			token.Add(t, nome)
+		}
+	}
+
+	//origini ammesse per le richieste API da un'altra applicazione,
+	//separate da virgole nella variabile d'ambiente RICORDALISTA_ORIGINI
+	origini := []string{"http://localhost:*", "http://127.0.0.1:*", "https://localhost:*", "https://127.0.0.1:*"}
+	if v := os.Getenv("RICORDALISTA_ORIGINI"); v != "" {
+		origini = strings.Split(strings.ReplaceAll(v, " ", ""), ",")
+	}
+
+	//metriche delle richieste e numero di note per utente, lette da Prometheus nel percorso /metrics
+	metriche := web.NewMetrics(nil)
+	metriche.GaugeVecFunc("ricordalista_note_da_fare", "Note da fare per utente.", "utente", contaNote(todo.NoteDaFare))
+	metriche.GaugeVecFunc("ricordalista_note_fatte", "Note fatte per utente.", "utente", contaNote(todo.NoteFatte))
+
+	//imposta i middleware
+	app.Use(
+		web.AccessLog(web.AccessLogOptions{Logger: slog.New(slog.NewTextHandler(os.Stderr, nil))}),
+		app.Instrument(metriche),
+		web.HSTS(web.HSTSOptions{}),
+		app.CORS(web.CORSOptions{
+			AllowedOrigins: origini,
+			ExposedHeaders: []string{"ETag", web.RequestIDHeader},
+			MaxAge:         10 * time.Minute}),
+		app.Authenticate(sessioni, utenti, token),
+		app.CSRF(web.CSRFOptions{CookieName: "ricordalista_csrf"}),
+		web.Compress(web.CompressOptions{}),
+		web.ETag(true))
+
+	//limita le richieste che aggiungono dati e i tentativi di accesso:
+	//20 consecutive, poi una ogni 5 secondi per utente o, prima dell'accesso, per indirizzo IP
+	limite := app.RateLimit(web.RateLimitOptions{Rate: 0.2, Burst: 20, Key: chiaveUtente})
+
+	//percorsi riservati agli utenti autenticati
+	riservato := app.RequireAuth("/accedi")
+
+	//controlli per il supervisore del processo: il percorso /healthz risponde finché il server è attivo,
+	//il percorso /readyz verifica anche il database e fallisce dall'inizio della chiusura
+	salute := web.NewHealth(web.HealthOptions{DrainDelay: 2 * time.Second})
+	salute.AddReadinessCheck("database", gn.Verifica)
+
+	//imposta i percorsi con i metodi ammessi
+	app.EnlistMethodFuncOK(http.MethodGet, "/accedi", mostraAccesso)
+	app.EnlistMethodOK(http.MethodPost, "/accedi", web.Chain(http.HandlerFunc(accedi), limite))
+	app.EnlistMethodOK(http.MethodPost, "/esci", web.Chain(http.HandlerFunc(esci), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/", web.Chain(http.HandlerFunc(mostraHomepage), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/note/{filtro}", web.Chain(http.HandlerFunc(mostraHomepage), riservato))
+	app.EnlistMethodOK(http.MethodPost, "/inserisci", web.Chain(http.HandlerFunc(aggiungiNota), riservato, limite))
+	app.EnlistMethodOK(http.MethodGet, "/nota/{id}", web.Chain(http.HandlerFunc(dettaglioNota), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/modifica/{id}", web.Chain(http.HandlerFunc(modificaNota), riservato))
+	app.EnlistMethodOK(http.MethodPost, "/aggiorna", web.Chain(http.HandlerFunc(aggiornaNota), riservato, limite))
+	app.EnlistMethodOK(http.MethodPost, "/cambia/{id}", web.Chain(http.HandlerFunc(cambiaStato), riservato))
+	app.EnlistMethodOK(http.MethodPost, "/collega", web.Chain(http.HandlerFunc(collegaNota), riservato, limite))
+	app.EnlistMethodOK(http.MethodPost, "/scollega/{id}/{altra}", web.Chain(http.HandlerFunc(scollegaNota), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/avviso/rimuovi/{id}", web.Chain(http.HandlerFunc(avvisoRimuovi), riservato))
+	app.EnlistMethodOK(http.MethodPost, "/conferma/rimuovi/{id}", web.Chain(http.HandlerFunc(rimuoviNota), riservato))
+	app.EnlistMethodOK(http.MethodDelete, "/conferma/rimuovi/{id}", web.Chain(http.HandlerFunc(rimuoviNota), riservato))
+	app.EnlistMethodOK(http.MethodPost, "/chiudi", web.Chain(http.HandlerFunc(chiudiApp), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/api/note/{id}", web.Chain(http.HandlerFunc(apiMostraNota), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/esporta", web.Chain(http.HandlerFunc(esportaNote), riservato))
+	app.EnlistMethodOK(http.MethodGet, "/metrics", web.Chain(metriche, riservato))
+	app.EnlistMethodFuncOK(http.MethodGet, "/healthz", salute.ServeLiveness)
+	app.EnlistMethodFuncOK(http.MethodGet, "/readyz", salute.ServeReadiness)
+
+	//imposta il gestore dei file pubblici
+	app.EnlistMethodOK(http.MethodGet, "/img/", statici)
+	app.EnlistMethodOK(http.MethodGet, "/files/", statici)
+
+	//crea il server: con la variabile d'ambiente RICORDALISTA_HTTPS, ad esempio ":8443",
+	//usa HTTPS su quell'indirizzo e la porta 8080 reindirizza le richieste a HTTPS
 	server = &http.Server{Addr: ":8080", Handler: app}
+	https := os.Getenv("RICORDALISTA_HTTPS")
+	if https != "" {
+		server.Addr = https
+	}
 
-	log.Fatalln(server.ListenAndServe())
+	//avvia il server e alla chiusura segnala che non è pronto, poi chiude il gestore note
+	ciclo = web.NewLifecycle(server, 10*time.Second, nil)
+	ciclo.BeforeShutdown("prontezza", salute.Shutdown)
+	ciclo.OnShutdown("gestore note", func(ctx context.Context) error {
+		gn.Chiudi()
+		return nil
+	})
+	if https != "" {
+		//senza certificato ne crea uno autofirmato, ricaricato se i file sono sostituiti
+		err = ciclo.RunTLS(web.TLSOptions{
+			CertFile:     "privato/cert.pem",
+			KeyFile:      "privato/chiave.pem",
+			SelfSigned:   true,
+			RedirectAddr: ":8080"})
+	} else {
+		err = ciclo.Run()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+//caricaModelli restituisce i template delle pagine letti dalle risorse dell'applicazione.
+func caricaModelli() (*template.Template, error) {
+	//crea la mappa delle funzioni per i template
+	fm := template.FuncMap{
+		"msg":        func() string { return "" },
+		"filtro":     func() todo.FiltroElenco { return todo.NessunFiltro },
+		"nomefiltro": nomeFiltro,
+		"gestore":    recuperaGestore,
+		"errori":     func(campo string) []string { return nil },
+		"valore":     func() string { return "" },
+		"asset":      statici.URL,
+		"utente":     func() string { return "" },
+		"csrf":       func() template.HTML { return "" },
+		"token":      func() string { return "" }}
+
+	return template.New("").Funcs(fm).ParseFS(risorse, "privato/modelli/home.html", "privato/modelli/modifica.html", "privato/modelli/elimina.html", "privato/modelli/nota.html", "privato/modelli/accedi.html")
+}
+
+//filtroRichiesta restituisce il filtro per l'elenco delle note indicato nel percorso /note/{filtro}
+//oppure nel parametro filtro della richiesta, NessunFiltro se assente o non valido.
+func filtroRichiesta(r *http.Request) todo.FiltroElenco {
+	nome, ok := strings.CutPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/note/")
+	if !ok {
+		nome = r.FormValue("filtro")
+	}
+	return filtriElenco[nome]
 }
 
-//recuperaFiltro restituisce il filtro per l'elenco delle note.
+//nomeFiltro restituisce il nome del filtro specificato usato nei percorsi e nel parametro filtro.
 //Funzione usata nei template.
-func recuperaFiltro() (f todo.FiltroElenco) {
-	return filtro
+func nomeFiltro(f todo.FiltroElenco) string {
+	for nome, v := range filtriElenco {
+		if (v == f) && (nome != "tutte") {
+			return nome
+		}
+	}
+	return "tutte"
+}
+
+//paginaElenco restituisce il percorso dell'elenco delle note con il filtro della richiesta.
+func paginaElenco(r *http.Request) string {
+	if f := filtroRichiesta(r); f != todo.NessunFiltro {
+		return "/note/" + nomeFiltro(f)
+	}
+	return "/"
+}
+
+//recuperaGestore restituisce il gestore delle note.
+//Funzione usata nei template, sostituita in mostraPagina da quella dell'utente della richiesta.
+func recuperaGestore() *todo.Gestore {
+	return gn
+}
+
+//caricaUtenti legge gli utenti dal file specificato.
+//Se il file non esiste, lo crea con l'utente admin e una password casuale scritta nel log.
+func caricaUtenti(percorso string) (*web.BasicAuth, error) {
+	if _, err := os.Stat(percorso); errors.Is(err, os.ErrNotExist) {
+		password := web.NewToken()[:16]
+		hash, err := web.HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		riga := "# utenti di RicordaLista, una riga nome:hash bcrypt per utente\nadmin:" + hash + "\n"
+		//la cartella non esiste se l'eseguibile è avviato da solo
+		if err = os.MkdirAll(filepath.Dir(percorso), 0700); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(percorso, []byte(riga), 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Creato il file degli utenti %s con l'utente admin e la password %s\n", percorso, password)
+	}
+	return web.LoadBasicAuth("RicordaLista", percorso)
 }
 
-//usaMessaggio restituisce il messaggio impostato nelle funzioni di gestione e lo cancella.
+//nomeUtente restituisce il nome dell'utente autenticato della richiesta, vuoto per le richieste anonime.
 //Funzione usata nei template.
-func usaMessaggio() (m string) {
-	m = uiMsg
-	uiMsg = "" //messaggio temporaneo, svuota la stringa
-	return
+func nomeUtente(r *http.Request) string {
+	if p := web.RequestPrincipal(r); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+//chiaveUtente restituisce la chiave dell'utente autenticato per il limite delle richieste,
+//vuota per le richieste anonime che sono limitate per indirizzo IP.
+func chiaveUtente(r *http.Request) string {
+	if p := web.RequestPrincipal(r); p != nil {
+		return "utente:" + p.Name
+	}
+	return ""
+}
+
+//gestoreRichiesta restituisce il gestore delle note dell'utente autenticato della richiesta,
+//creando l'utente nel database al primo accesso.
+//Il primo utente creato riceve le note e le liste create prima dell'introduzione degli utenti.
+//Per le richieste anonime restituisce il gestore delle note senza utente.
+func gestoreRichiesta(r *http.Request) *todo.Gestore {
+	p := web.RequestPrincipal(r)
+	if p == nil {
+		return gn
+	}
+	ut, err := gn.RecuperaUtente(p.Name)
+	if errors.Is(err, todo.ErrUtenteNonTrovato) {
+		var id int64
+		if id, err = gn.AggiungiUtente(p.Name); err == nil {
+			if n, errAdotta := gn.AdottaNoteDefault(id); errAdotta != nil {
+				log.Printf("Note senza utente non assegnate a %s: %v\n", p.Name, errAdotta)
+			} else if n > 0 {
+				log.Printf("Assegnate %d note senza utente a %s\n", n, p.Name)
+			}
+		}
+		if errors.Is(err, todo.ErrUtenteEsistente) || (err == nil) {
+			//l'utente può essere stato creato da una richiesta contemporanea
+			ut, err = gn.RecuperaUtente(p.Name)
+		}
+	}
+	if err != nil {
+		log.Printf("Utente %s non disponibile: %v\n", p.Name, err)
+		return gn
+	}
+	return gn.PerUtente(ut.GetID())
+}
+
+//contaNote restituisce la funzione della metrica con il numero di note selezionate dal filtro
+//per ogni utente del file degli utenti che ha già effettuato l'accesso.
+func contaNote(filtro todo.FiltroElenco) func() map[string]float64 {
+	return func() map[string]float64 {
+		valori := make(map[string]float64)
+		for _, nome := range utenti.Users() {
+			if ut, err := gn.RecuperaUtente(nome); err == nil {
+				valori[nome] = float64(gn.PerUtente(ut.GetID()).Totale(filtro))
+			}
+		}
+		return valori
+	}
+}
+
+//impostaAvviso modifica con la funzione specificata l'avviso dell'utente della richiesta.
+//Le richieste anonime non hanno avvisi, così utenti diversi non vedono i messaggi degli altri.
+func impostaAvviso(r *http.Request, fn func(av *avviso)) {
+	nome := nomeUtente(r)
+	if nome == "" {
+		return
+	}
+	avvisiMu.Lock()
+	defer avvisiMu.Unlock()
+	av := avvisi[nome]
+	fn(&av)
+	avvisi[nome] = av
+}
+
+//usaAvviso restituisce l'avviso dell'utente della richiesta e lo cancella.
+func usaAvviso(r *http.Request) avviso {
+	nome := nomeUtente(r)
+	avvisiMu.Lock()
+	defer avvisiMu.Unlock()
+	av := avvisi[nome]
+	delete(avvisi, nome) //avviso temporaneo, mostrato una sola volta
+	return av
+}
+
+//inviaErrori invia all'utente gli errori di convalida di una nota.
+//Restituisce true se gli errori sono mostrati con reindirizzamento.
+func inviaErrori(w http.ResponseWriter, r *http.Request, redirectHome bool, errori todo.ErroriConvalida) bool {
+	if vuoleJSON(r) {
+		//vuole risposta in JSON, invia un problema con gli errori dei campi
+		problema := web.NewProblem(http.StatusBadRequest, "Nota non valida.")
+		problema.Extensions = map[string]any{"errori": errori}
+		app.ReplyProblem(problema, w, r)
+		return false
+	}
+
+	impostaAvviso(r, func(av *avviso) { av.errori = errori })
+	return inviaMessaggio(w, r, redirectHome, http.StatusBadRequest, "Nota non valida.")
+}
+
+//vuoleJSON restituisce true se la richiesta preferisce una risposta in JSON a una pagina HTML.
+//Per le richieste che accettano entrambi i formati con lo stesso peso, ad esempio */*, è scelta la pagina HTML.
+func vuoleJSON(r *http.Request) bool {
+	return (web.Negotiate(r, "text/html", "application/json") == "application/json")
 }
 
 //inviaMessaggio invia un messaggio all'utente.
 //Restituisce true se il messaggio è mostrato con reindirizzamento.
 func inviaMessaggio(w http.ResponseWriter, r *http.Request, redirectHome bool, code int, msg string) bool {
-	if web.CheckAccept(r, []string{"application/json"}, false, w) {
+	if vuoleJSON(r) {
 		//vuole risposta in JSON
-		risultato := RisultatoAPI{OK: (code == http.StatusOK), Messaggio: msg}
-		web.ServeJSON(r, risultato, code, w)
+		if code >= 400 {
+			//errore, invia un problema
+			app.ReplyStatus(code, msg, w, r)
+		} else {
+			web.ServeJSON(r, RisultatoAPI{OK: (code == http.StatusOK), Messaggio: msg}, code, w)
+		}
 		return false
 	}
 
-	uiMsg = msg
+	impostaAvviso(r, func(av *avviso) { av.msg = msg })
 	if code < 300 || code > 399 {
 		code = http.StatusFound
 	}
 	if redirectHome {
-		http.Redirect(w, r, "/", code)
+		http.Redirect(w, r, paginaElenco(r), code)
 	}
 	return true
 }
 
-//mostraPagina risponde ad una richiesta eseguendo il template specificato.
+//mostraPagina risponde ad una richiesta eseguendo il template specificato
+//con il gestore delle note, il nome dell'utente, il token CSRF, il filtro e l'avviso della richiesta.
+//L'avviso è letto e cancellato solo se il template lo usa.
 func mostraPagina(nome string, dati interface{}, w http.ResponseWriter, r *http.Request) bool {
-	err := modelli.ExecuteTemplate(w, nome+".html", dati)
+	//i template originali non sono mai eseguiti, così possono essere clonati per ogni richiesta;
+	//durante lo sviluppo sono riletti dalla cartella delle risorse
+	var t *template.Template
+	var err error
+	if sviluppo {
+		t, err = caricaModelli()
+	} else {
+		t, err = modelli.Clone()
+	}
+	if err == nil {
+		gu := gestoreRichiesta(r)
+		var av *avviso
+		leggiAvviso := func() *avviso {
+			if av == nil {
+				v := usaAvviso(r)
+				av = &v
+			}
+			return av
+		}
+		t.Funcs(template.FuncMap{
+			"gestore": func() *todo.Gestore { return gu },
+			"utente":  func() string { return nomeUtente(r) },
+			"csrf":    func() template.HTML { return web.CSRFField(r) },
+			"token":   func() string { return web.CSRFToken(r) },
+			"filtro":  func() todo.FiltroElenco { return filtroRichiesta(r) },
+			"msg":     func() string { return leggiAvviso().msg },
+			"errori":  func(campo string) []string { return leggiAvviso().errori.Messaggi(campo) },
+			"valore":  func() string { return leggiAvviso().valore }})
+		err = t.ExecuteTemplate(w, nome+".html", dati)
+	}
 
 	if err != nil {
 		app.ReplyStatus(http.StatusInternalServerError, err.Error(), w, r)
@@ -117,9 +460,10 @@ func mostraPagina(nome string, dati interface{}, w http.ResponseWriter, r *http.
 	return (err == nil)
 }
 
-//mostraPaginaNota recupera la nota con id specificato in query string ed esegue il template specificato.
+//mostraPaginaNota recupera la nota con id specificato nel percorso ed esegue il template specificato.
 func mostraPaginaNota(nome string, w http.ResponseWriter, r *http.Request) {
-	idstr := r.URL.Query().Get("id")
+	gu := gestoreRichiesta(r)
+	idstr := web.PathParam(r, "id")
 
 	var err error
 	var id int64
@@ -132,7 +476,7 @@ func mostraPaginaNota(nome string, w http.ResponseWriter, r *http.Request) {
 
 	var nt *todo.Nota
 
-	nt, err = gn.Recupera(id)
+	nt, err = gu.Recupera(id)
 
 	if err != nil {
 		inviaMessaggio(w, r, true, http.StatusNotFound, fmt.Sprintf("Nota con ID '%s' non trovata.", idstr))
@@ -142,121 +486,115 @@ func mostraPaginaNota(nome string, w http.ResponseWriter, r *http.Request) {
 	mostraPagina(nome, nt, w, r)
 }
 
-//mostraHomepage gestisce la pagina iniziale.
+//mostraHomepage gestisce la pagina iniziale, con l'elenco delle note selezionate dal filtro nel percorso.
 func mostraHomepage(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimSuffix(r.URL.Path, "/")
-
-	switch path {
-	case "/note/tutte":
-		filtro = todo.NessunFiltro
-	case "/note/fatte":
-		filtro = todo.NoteFatte
-	case "/note/dafare":
-		filtro = todo.NoteDaFare
-	}
-
-	mostraPagina("home", gn, w, r)
+	mostraPagina("home", gestoreRichiesta(r), w, r)
 }
 
 //aggiungiNota gestisce l'aggiunta di una nota e reindirizza alla homepage.
 func aggiungiNota(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodPost}, true, w) {
-		return
-	}
-
+	gu := gestoreRichiesta(r)
 	testo := strings.TrimSpace(r.FormValue("nota"))
 
-	if len(testo) == 0 {
-		inviaMessaggio(w, r, true, http.StatusBadRequest, "Specifica il testo della nota.")
-		return
-	}
-
-	if _, err := gn.Aggiungi(testo); err == nil {
+	if _, err := gu.Aggiungi(testo); err == nil {
 		inviaMessaggio(w, r, true, http.StatusOK, "Nota aggiunta con successo.")
+	} else if errori, ok := err.(todo.ErroriConvalida); ok {
+		impostaAvviso(r, func(av *avviso) { av.valore = testo })
+		inviaErrori(w, r, true, errori)
 	} else {
 		inviaMessaggio(w, r, true, http.StatusBadRequest, fmt.Sprintf("Operazione non riuscita: %s", err))
 	}
 }
 
+//dettaglioNota gestisce la pagina con i dettagli di una nota.
+func dettaglioNota(w http.ResponseWriter, r *http.Request) {
+	mostraPaginaNota("nota", w, r)
+}
+
 //modificaNota gestisce la pagina per modificare una nota.
 func modificaNota(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodGet}, true, w) {
-		return
-	}
-
 	mostraPaginaNota("modifica", w, r)
 }
 
+//NotaModulo rappresenta i dati del modulo di modifica di una nota.
+//Il corpo resta invariato se il campo "corpo" è assente.
+type NotaModulo struct {
+	ID    int64   `form:"id" validate:"required"`
+	Testo string  `form:"nota"`
+	Corpo *string `form:"corpo"`
+	Fatto bool    `form:"fatto"`
+}
+
 //aggiornaNota gestisce l'aggiornamento di una nota.
+//Se la richiesta contiene If-Match, la nota è aggiornata solo se il suo ETag corrisponde, altrimenti la risposta è 412.
 func aggiornaNota(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodPost}, true, w) {
-		return
-	}
-
+	gu := gestoreRichiesta(r)
 	var err error
 	var id int64
 	var testo string
+	var corpo *string
 	var fatto bool
 
 	switch strings.Split(r.Header.Get("Content-Type"), ";")[0] {
 	case "application/json":
-		napi := leggiNotaAPI(r)
-		if napi.Valida {
-			id = napi.ID
-			testo = strings.TrimSpace(napi.Testo)
-			fatto = napi.Fatto
-		} else {
-			inviaMessaggio(w, r, true, http.StatusBadRequest, "Dati nota non validi.")
+		napi, ok := leggiNotaAPI(w, r)
+		if !ok {
 			return
 		}
-	case "application/x-www-form-urlencoded":
-		idstr := r.FormValue("id")
-		testo = strings.TrimSpace(r.FormValue("nota"))
-		fatto = (r.FormValue("fatto") == "true")
-		id, err = strconv.ParseInt(idstr, 10, 64)
-		if err != nil {
-			inviaMessaggio(w, r, true, http.StatusBadRequest, fmt.Sprintf("ID nota '%s' non valido.", idstr))
+		id = napi.ID
+		testo = strings.TrimSpace(napi.Testo)
+		corpo = napi.Corpo
+		fatto = napi.Fatto
+	default:
+		var modulo NotaModulo
+		if web.DecodeForm(r, &modulo, 0, w) != nil {
 			return
 		}
-	default:
-		inviaMessaggio(w, r, true, http.StatusBadRequest, "Formato richiesta non valido.")
-		return
+		id = modulo.ID
+		testo = strings.TrimSpace(modulo.Testo)
+		corpo = modulo.Corpo
+		fatto = modulo.Fatto
 	}
 
 	var nt *todo.Nota
 
-	nt, err = gn.Recupera(id)
+	nt, err = gu.Recupera(id)
 
 	if err != nil {
 		inviaMessaggio(w, r, true, http.StatusNotFound, fmt.Sprintf("Nota con ID '%d' non trovata.", id))
 		return
 	}
 
-	if len(testo) == 0 {
-		if inviaMessaggio(w, r, false, http.StatusBadRequest, "Specifica il testo della nota.") {
-			mostraPagina("modifica", nt, w, r)
-		}
+	//aggiornamento condizionato: con If-Match la nota non deve essere cambiata dopo la lettura
+	if !web.CheckConditions(r, etagNota(nt), time.Time{}, w) {
 		return
 	}
 
 	nt.Testo(testo)
+	if corpo != nil {
+		nt.Corpo(*corpo)
+	}
 	nt.Fatto = fatto
 
-	if err = gn.Aggiorna(nt); err == nil {
+	if err = gu.Aggiorna(nt); err == nil {
+		w.Header().Set("ETag", etagNota(nt))
 		inviaMessaggio(w, r, true, http.StatusOK, "Nota aggiornata con successo.")
+	} else if errori, ok := err.(todo.ErroriConvalida); ok {
+		if inviaErrori(w, r, false, errori) {
+			mostraPagina("modifica", nt, w, r)
+		}
+	} else if err == todo.ErrNotaBloccata {
+		tornaNota(w, r, id, http.StatusConflict, "La nota è bloccata da note da fare.")
 	} else {
-		inviaMessaggio(w, r, true, http.StatusInternalServerError, fmt.Sprintf("Errore %s", err))
+		inviaErroreNota(w, r, id, err)
 	}
 }
 
 //cambiaStato gestisce la modifica dello stato di una nota.
 func cambiaStato(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodGet, http.MethodPost}, true, w) {
-		return
-	}
-
+	gu := gestoreRichiesta(r)
 	valori := r.URL.Query()
-	idstr := valori.Get("id")
+	idstr := web.PathParam(r, "id")
 
 	var err error
 	var id int64
@@ -269,7 +607,7 @@ func cambiaStato(w http.ResponseWriter, r *http.Request) {
 
 	var nt *todo.Nota
 
-	nt, err = gn.Recupera(id)
+	nt, err = gu.Recupera(id)
 
 	if err != nil {
 		inviaMessaggio(w, r, true, http.StatusNotFound, fmt.Sprintf("Nota con ID '%s' non trovata.", idstr))
@@ -279,8 +617,17 @@ func cambiaStato(w http.ResponseWriter, r *http.Request) {
 	fatto := (valori.Get("fatto") == "true")
 
 	if nt.Fatto != fatto {
-		if err = gn.CambiaStato(id, fatto); err != nil {
-			inviaMessaggio(w, r, true, http.StatusInternalServerError, fmt.Sprintf("Errore %s", err))
+		if valori.Get("forza") == "true" {
+			err = gu.CambiaStatoForzato(id, fatto)
+		} else {
+			err = gu.CambiaStato(id, fatto)
+		}
+		if err == todo.ErrNotaBloccata {
+			inviaMessaggio(w, r, true, http.StatusConflict, "La nota è bloccata da note da fare: per segnarla come fatta usa la pagina di modifica.")
+			return
+		}
+		if err != nil {
+			inviaErroreNota(w, r, id, err)
 			return
 		}
 	}
@@ -288,22 +635,109 @@ func cambiaStato(w http.ResponseWriter, r *http.Request) {
 	inviaMessaggio(w, r, true, http.StatusOK, "Nota aggiornata con successo.")
 }
 
-//avvisoRimuovi chiede conferma di rimuovere una nota.
-func avvisoRimuovi(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodGet}, true, w) {
+//inviaErroreNota invia all'utente l'errore restituito dal gestore per la nota con id specificato
+//e reindirizza alla homepage: 404 se la nota non è accessibile all'utente, 403 se l'utente
+//non ha il permesso necessario, 500 per gli altri errori.
+func inviaErroreNota(w http.ResponseWriter, r *http.Request, id int64, err error) {
+	switch {
+	case errors.Is(err, todo.ErrNotaNonTrovata):
+		inviaMessaggio(w, r, true, http.StatusNotFound, fmt.Sprintf("Nota con ID '%d' non trovata.", id))
+	case errors.Is(err, todo.ErrPermessoNegato):
+		inviaMessaggio(w, r, true, http.StatusForbidden, fmt.Sprintf("Non hai il permesso di modificare la nota con ID '%d'.", id))
+	default:
+		inviaMessaggio(w, r, true, http.StatusInternalServerError, fmt.Sprintf("Errore %s", err))
+	}
+}
+
+//tornaNota invia un messaggio all'utente e reindirizza alla pagina di modifica della nota.
+func tornaNota(w http.ResponseWriter, r *http.Request, id int64, code int, msg string) {
+	if inviaMessaggio(w, r, false, code, msg) {
+		http.Redirect(w, r, fmt.Sprintf("/modifica/%d", id), http.StatusFound)
+	}
+}
+
+//collegaNota gestisce la creazione di un legame fra due note.
+func collegaNota(w http.ResponseWriter, r *http.Request) {
+	gu := gestoreRichiesta(r)
+	var err error
+	var id, altra int64
+
+	idstr := r.FormValue("id")
+	id, err = strconv.ParseInt(idstr, 10, 64)
+	if err != nil {
+		inviaMessaggio(w, r, true, http.StatusBadRequest, fmt.Sprintf("ID nota '%s' non valido.", idstr))
 		return
 	}
 
-	mostraPaginaNota("elimina", w, r)
+	altrastr := r.FormValue("altra")
+	altra, err = strconv.ParseInt(altrastr, 10, 64)
+	if err != nil {
+		tornaNota(w, r, id, http.StatusBadRequest, fmt.Sprintf("ID nota '%s' non valido.", altrastr))
+		return
+	}
+
+	switch r.FormValue("tipo") {
+	case "blocca":
+		err = gu.Collega(id, altra, todo.LegameBlocca)
+	case "bloccata":
+		err = gu.Collega(altra, id, todo.LegameBlocca)
+	default:
+		err = gu.Collega(id, altra, todo.LegameCorrelato)
+	}
+
+	switch err {
+	case nil:
+		tornaNota(w, r, id, http.StatusOK, "Legame aggiunto con successo.")
+	case todo.ErrNotaNonTrovata:
+		tornaNota(w, r, id, http.StatusNotFound, fmt.Sprintf("Nota con ID '%d' non trovata.", altra))
+	case todo.ErrPermessoNegato:
+		tornaNota(w, r, id, http.StatusForbidden, "Non hai il permesso di modificare entrambe le note.")
+	case todo.ErrLegameNonValido:
+		tornaNota(w, r, id, http.StatusBadRequest, "Una nota non può essere legata a sé stessa.")
+	case todo.ErrCicloDipendenze:
+		tornaNota(w, r, id, http.StatusConflict, "Il legame creerebbe un ciclo di dipendenze.")
+	default:
+		tornaNota(w, r, id, http.StatusInternalServerError, fmt.Sprintf("Errore %s", err))
+	}
 }
 
-//rimuoviNota gestisce la rimozione di una nota.
-func rimuoviNota(w http.ResponseWriter, r *http.Request) {
-	if !web.CheckMethod(r, []string{http.MethodGet, http.MethodDelete}, true, w) {
+//scollegaNota gestisce la rimozione di un legame fra due note.
+func scollegaNota(w http.ResponseWriter, r *http.Request) {
+	gu := gestoreRichiesta(r)
+	var err error
+	var id, altra int64
+
+	idstr := web.PathParam(r, "id")
+	id, err = strconv.ParseInt(idstr, 10, 64)
+	if err != nil {
+		inviaMessaggio(w, r, true, http.StatusBadRequest, fmt.Sprintf("ID nota '%s' non valido.", idstr))
+		return
+	}
+
+	altrastr := web.PathParam(r, "altra")
+	altra, err = strconv.ParseInt(altrastr, 10, 64)
+	if err != nil {
+		tornaNota(w, r, id, http.StatusBadRequest, fmt.Sprintf("ID nota '%s' non valido.", altrastr))
+		return
+	}
+
+	if err = gu.Scollega(id, altra); err != nil {
+		inviaErroreNota(w, r, id, err)
 		return
 	}
 
-	idstr := r.URL.Query().Get("id")
+	tornaNota(w, r, id, http.StatusOK, "Legame rimosso.")
+}
+
+//avvisoRimuovi chiede conferma di rimuovere una nota.
+func avvisoRimuovi(w http.ResponseWriter, r *http.Request) {
+	mostraPaginaNota("elimina", w, r)
+}
+
+//rimuoviNota gestisce la rimozione di una nota.
+func rimuoviNota(w http.ResponseWriter, r *http.Request) {
+	gu := gestoreRichiesta(r)
+	idstr := web.PathParam(r, "id")
 
 	var err error
 	var id int64
@@ -314,24 +748,61 @@ func rimuoviNota(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = gn.Elimina(id); err != nil {
-		inviaMessaggio(w, r, true, http.StatusInternalServerError, fmt.Sprintf("Errore %s", err))
+	if err = gu.Elimina(id); err != nil {
+		inviaErroreNota(w, r, id, err)
 		return
 	}
 
 	inviaMessaggio(w, r, true, http.StatusOK, "Nota eliminata.")
 }
 
-//chiudiApp avvia la chiusura e mostra una pagina per informare l'utente.
+//chiudiApp mostra una pagina per informare l'utente e avvia la chiusura.
+//Il server attende il completamento di questa e delle altre richieste in corso prima di chiudersi.
 func chiudiApp(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "<html><head></head><body>La connessione &egrave; terminata.<br/>Puoi chiudere il browser.<br/>Arrivederci.</body></html>")
-	go chiudi()
+	ciclo.Stop()
+}
+
+//DatiAccesso contiene i dati della pagina di accesso.
+type DatiAccesso struct {
+	Nome      string
+	Messaggio string
+	Ritorno   string
+}
+
+//percorsoRitorno restituisce il percorso locale a cui tornare dopo l'accesso, "/" se non è valido.
+//I percorsi assoluti o che iniziano con // sono rifiutati per non reindirizzare l'utente su un altro sito.
+func percorsoRitorno(ritorno string) string {
+	if !strings.HasPrefix(ritorno, "/") || strings.HasPrefix(ritorno, "//") || strings.HasPrefix(ritorno, "/\\") {
+		return "/"
+	}
+	return ritorno
+}
+
+//mostraAccesso mostra la pagina di accesso con nome utente e password.
+func mostraAccesso(w http.ResponseWriter, r *http.Request) {
+	mostraPagina("accedi", DatiAccesso{Ritorno: percorsoRitorno(r.FormValue("next"))}, w, r)
+}
+
+//accedi verifica nome utente e password, crea la sessione e reindirizza al percorso richiesto prima dell'accesso.
+//Se le credenziali non sono valide mostra di nuovo la pagina di accesso con il codice 401.
+func accedi(w http.ResponseWriter, r *http.Request) {
+	nome := strings.TrimSpace(r.FormValue("nome"))
+	ritorno := percorsoRitorno(r.FormValue("next"))
+
+	if !utenti.Verify(nome, r.FormValue("password")) {
+		log.Printf("Accesso non riuscito per l'utente %q\n", nome)
+		w.WriteHeader(http.StatusUnauthorized)
+		mostraPagina("accedi", DatiAccesso{Nome: nome, Messaggio: "Nome utente o password non validi.", Ritorno: ritorno}, w, r)
+		return
+	}
+
+	sessioni.Login(w, r, nome)
+	http.Redirect(w, r, ritorno, http.StatusSeeOther)
 }
 
-//chiude server, gestore note ed esce dall'applicazione
-func chiudi() {
-	time.Sleep(3 * time.Second)
-	server.Close()
-	gn.Chiudi()
-	os.Exit(0)
+//esci termina la sessione dell'utente e torna alla pagina di accesso.
+func esci(w http.ResponseWriter, r *http.Request) {
+	sessioni.Logout(w, r)
+	http.Redirect(w, r, "/accedi", http.StatusSeeOther)
 }
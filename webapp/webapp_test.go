@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Renato Mite. Tutti i diritti riservati. All rights reserved.
+// Questa applicazione web è descritta nella guida
+// "Programmare in Linguaggio Go - La guida italiana per muovere i primi passi"
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rmite/todo"
+	web "rmite/webman"
+)
+
+func TestNoteAltroUtente(t *testing.T) {
+	var err error
+	if gn, err = todo.NewGestore(filepath.Join(t.TempDir(), "note.db")); err != nil {
+		t.Fatalf("ERR : Apertura del database non riuscita: %v \n", err)
+	}
+	defer gn.Chiudi()
+
+	idAnna, _ := gn.AggiungiUtente("anna")
+	idBruno, _ := gn.AggiungiUtente("bruno")
+	anna := gn.PerUtente(idAnna)
+	privata, _ := anna.Aggiungi("privata")
+	condivisa, _ := anna.Aggiungi("condivisa")
+	altra, _ := anna.Aggiungi("altra")
+	anna.Collega(privata, altra, todo.LegameCorrelato)
+	anna.Collega(condivisa, altra, todo.LegameCorrelato)
+	if err = anna.CondividiNota(condivisa, idBruno, todo.PermessoLettura); err != nil {
+		t.Fatalf("ERR : Condivisione della nota %d non riuscita: %v \n", condivisa, err)
+	}
+
+	app = web.NewServerManager(log.New(io.Discard, "", 0))
+	app.Use(app.Authenticate(web.NewTokenAuth(map[string]string{"token-bruno": "bruno"})))
+	app.EnlistMethodFuncOK(http.MethodPost, "/aggiorna", aggiornaNota)
+	app.EnlistMethodFuncOK(http.MethodPost, "/cambia/{id}", cambiaStato)
+	app.EnlistMethodFuncOK(http.MethodPost, "/scollega/{id}/{altra}", scollegaNota)
+	app.EnlistMethodFuncOK(http.MethodPost, "/conferma/rimuovi/{id}", rimuoviNota)
+
+	dati := []struct {
+		path   string
+		corpo  string
+		codice int
+	}{
+		{"/aggiorna", fmt.Sprintf("id=%d&nota=modificata", privata), http.StatusNotFound},
+		{"/aggiorna", fmt.Sprintf("id=%d&nota=modificata", condivisa), http.StatusForbidden},
+		{fmt.Sprintf("/cambia/%d?fatto=true", privata), "", http.StatusNotFound},
+		{fmt.Sprintf("/cambia/%d?fatto=true", condivisa), "", http.StatusForbidden},
+		{fmt.Sprintf("/scollega/%d/%d", privata, altra), "", http.StatusNotFound},
+		{fmt.Sprintf("/scollega/%d/%d", condivisa, altra), "", http.StatusForbidden},
+		{fmt.Sprintf("/conferma/rimuovi/%d", privata), "", http.StatusNotFound},
+		{fmt.Sprintf("/conferma/rimuovi/%d", condivisa), "", http.StatusForbidden},
+	}
+
+	for _, d := range dati {
+		req := httptest.NewRequest(http.MethodPost, d.path, strings.NewReader(d.corpo))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer token-bruno")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+
+		if w.Code != d.codice {
+			t.Errorf("ERR : La richiesta di Bruno POST %s %s risponde con il codice %d invece di %d \n", d.path, d.corpo, w.Code, d.codice)
+		} else {
+			t.Logf("MSG : La richiesta di Bruno POST %s %s risponde con il codice %d \n", d.path, d.corpo, w.Code)
+		}
+	}
+
+	if note := anna.Elenco(todo.NessunFiltro); len(note) != 3 {
+		t.Errorf("ERR : Anna ha %d note invece di 3 \n", len(note))
+	}
+	for _, id := range []int64{privata, condivisa} {
+		if nt, _ := anna.Recupera(id); nt.Fatto || len(anna.Correlate(id)) != 1 {
+			t.Errorf("ERR : La nota %d di Anna è stata modificata da Bruno \n", id)
+		}
+	}
+}